@@ -2,11 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
 	pb "github.com/inklabsfoundation/inkchain/protos/peer"
@@ -15,57 +22,373 @@ import (
 // Incentive-related const
 const (
 	IncentiveBalanceType  = "INK"
-	IncentiveMashupInvoke = "10"
+	IncentiveMashupInvoke = "10" // default of the MASHUP_INCENTIVE config
 )
 
+// Key of the amounts givesToken pays, by incentive type
+const IncentiveAmountsKey = "INCENTIVE_AMOUNTS"
+
+// Amounts givesToken pays by incentive type, seeded in Init and tuned
+// through setIncentiveAmount
+var defaultIncentiveAmounts = map[string]string{
+	// ************************ Developers token ***********************
+	"1": "110", // register service
+	"2": "110", // register mashup
+	"3": "110", // service is invoked
+	"4": "110", // user gives token to service provider
+	// ************************ Users token ***********************
+	"5": "510", // register user
+	"6": "110", // comments
+	"7": "110", // thumbs up/down (every 10)
+}
+
 // Definitions of a service's status
 const (
-	S_Created   = "created"
-	S_Available = "available"
-	S_Invalid   = "invalid"
+	S_Created    = "created"
+	S_Available  = "available"
+	S_Invalid    = "invalid"
+	S_Deprecated = "deprecated" // still works, but new mashups shouldn't build on it
 )
 
 // Prefixes for user and service separately
 const (
-	UserPrefix    = "USER_"
-	ServicePrefix = "SER_"
+	UserPrefix     = "USER_"
+	ServicePrefix  = "SER_"
+	AdminPrefix    = "ADMIN_"
+	ConfigPrefix   = "CONFIG_"
+	TreasuryPrefix = "TREASURY_"       // TREASURY_<tx timestamp>_<txid>
+	CommentPrefix  = "COMMENT_"        // COMMENT_<service>_<txid>
+	VersionPrefix  = "SERVER_VERSION_" // SERVER_VERSION_<service>_<version>
+	AccessPrefix   = "ACCESSLOG_"      // ACCESSLOG_<service>_<tx timestamp>_<txid>
+	AddressPrefix  = "ADDR_"           // ADDR_<address> -> name of the user registered with it
+)
+
+// Composite key indexing services by creation time, newest first:
+// service~invtime<max time - CreatedTime><name>
+const ServiceTimeIndex = "service~invtime"
+
+// Composite key of the former creation time index, oldest first:
+// service~time<CreatedTime><name>
+const LegacyServiceTimeIndex = "service~time"
+
+// Composite key listing the services of each developer: developer~service<developer><name>;
+// a service is listed under its developer's user name, a mashup under its
+// creator's address, which stays the same once the creator registers
+const DeveloperIndex = "developer~service"
+
+// Composite key listing the mashups compositing each service: component~mashup<service><mashup>
+const ComponentIndex = "component~mashup"
+
+// Composite key totalling what a user earned in a token: earned~user~token<user><token>
+const EarnedIndex = "earned~user~token"
+
+// Composite key recording one invocation of a service per transaction, so that
+// concurrent invocations never write the same key: invoke~service~txid<service><txid>
+const InvokeIndex = "invoke~service~txid"
+
+// Composite key recording the direction of each voter's vote on a
+// service: vote~service~voter<service><voter address>
+const VoteIndex = "vote~service~voter"
+
+// Maximum number of tags of a service
+const MaxServiceTags = 10
+
+// Maximum length of a comment, in characters
+const MaxCommentLength = 1000
+
+// Net upvotes a service needs for each thumbs up incentive
+const VotesPerIncentive = 10
+
+// Directions of a treasury ledger entry
+const (
+	TreasuryInflow  = "in"
+	TreasuryOutflow = "out"
+)
+
+// Key of the list of recently changed services, replaced by the
+// changed~time~txid~name index and only read for older changes
+const RecentChangedKey = "RECENT_CHANGED"
+
+// Composite key recording a service changed by a transaction, one key per
+// transaction and service: changed~time~txid~name<tx timestamp><txid><name>
+const ChangedIndex = "changed~time~txid~name"
+
+// Key of the protocol revenue collected on paid invocations, by token
+const StatsRevenueKey = "STATS_REVENUE"
+
+// Basis points of a whole, the unit of PROTOCOL_FEE_BPS
+const BasisPoints = 10000
+
+// Error codes prefixing the error messages clients may want to handle
+const (
+	ERR_BAD_ARGS           = "ERR_BAD_ARGS"
+	ERR_RANGE_UNSUPPORTED  = "ERR_RANGE_UNSUPPORTED" // the state database can't run the query, fall back to per-key queries
+	ERR_USER_NOT_FOUND     = "ERR_USER_NOT_FOUND"
+	ERR_RESPONSE_TOO_LARGE = "ERR_RESPONSE_TOO_LARGE" // narrow the query or page through the results
+)
+
+// Maximum number of groups findDuplicateDescriptions returns
+const MaxDuplicateGroups = 100
+
+// Number of hex digits of an INKchain address
+const AddressLength = 40
+
+// Maximum decimals of a token issued through initAccount
+const MaxTokenDecimals = 18
+
+// Maximum number of users queryTopContributors returns
+const MaxTopContributors = 100
+
+// Maximum nesting of mashups walked when checking a composition
+const MaxCompositionDepth = 16
+
+// Configurable parameters, tuned by admins through setConfig
+const (
+	ConfigDraftTTL   = "DRAFT_TTL"                    // days a service may stay in S_Created before being swept or reclaimed
+	ConfigMashupFee  = "MASHUP_FEE"                   // INK charged to a mashup's developer on creation
+	ConfigTreasury   = "TREASURY"                     // address collecting the ecosystem's fees
+	ConfigRecentSize = "RECENT_SIZE"                  // most changed services queryChangedSince reports
+	ConfigScanLimit  = "SCAN_LIMIT"                   // maximum records an analytics invoke scans
+	ConfigDecayPct   = "DECAY_PERCENT"                // contribution lost per inactive period, 0 disables the decay
+	ConfigDecayDays  = "DECAY_PERIOD"                 // length in days of an inactive period
+	ConfigQuota      = "SERVICE_QUOTA"                // maximum active services per developer, 0 for no limit
+	ConfigExternal   = "REQUIRE_EXTERNAL_COMPOSITION" // mashups must composite someone else's service
+	ConfigMaxPayload = "MAX_RESPONSE_SIZE"            // bytes a response payload may take, 0 for no limit
+	ConfigIncentive  = "MASHUP_INCENTIVE"             // INK paid to each composited developer on mashup creation
+	ConfigWPublished = "CONTRIB_PUBLISHED"            // contribution weight of a published service
+	ConfigWComposed  = "CONTRIB_COMPOSED"             // contribution weight of a mashup compositing a service
+	ConfigWReview    = "CONTRIB_REVIEW"               // contribution weight of a vote or comment received
+	ConfigMaxName    = "MAX_NAME_LENGTH"              // characters a user or service name may take
+	ConfigMaxDesc    = "MAX_DESCRIPTION_LENGTH"       // characters a service description may take
+	ConfigMaxIntro   = "MAX_INTRODUCTION_LENGTH"      // characters a user introduction may take
+	ConfigIncToken   = "INCENTIVE_TOKEN"              // token mashup incentives are paid in, issued through initAccount unless INK
+	ConfigDeprecated = "ALLOW_DEPRECATED_COMPOSITION" // mashups may composite deprecated services
+	ConfigFeeBps     = "PROTOCOL_FEE_BPS"             // share of a paid invocation sent to the treasury, in basis points
+	ConfigMaxComp    = "MAX_MASHUP_COMPOSITION"       // services a mashup may composite
+	ConfigMaxBatch   = "MAX_BATCH_SIZE"               // services registerServiceBatch, bulkAddTag or bulkRemoveTag may handle at once
+	ConfigDataPrice  = "DATA_PRICE"                   // INK paid to the developer for a queryServicePaid
+	ConfigTRating    = "TRUST_RATING"                 // trust score weight of a net upvote
+	ConfigTInvoke    = "TRUST_INVOKE"                 // trust score weight of an invocation
+	ConfigTContrib   = "TRUST_CONTRIB"                // trust score weight of a point of the developer's contribution
+	ConfigTAge       = "TRUST_AGE"                    // trust score weight of a day since the service's creation
 )
 
 // Invoke functions definition
 const (
 	// User-related basic invoke
-	RegisterUser = "registerUser"
-	RemoveUser   = "removeUser"
-	QueryUser    = "queryUser"
+	RegisterUser            = "registerUser"
+	RemoveUser              = "removeUser"
+	QueryUser               = "queryUser"
+	QueryUserByAddress      = "queryUserByAddress"
+	QueryBalance            = "queryBalance" // token balances of a user's address
+	UpdateUser              = "updateUser"
+	RecalculateContribution = "recalculateContribution"
+	VerifyOwnership         = "verifyOwnership" // whether the sender owns a user
+	QueryTopContributors    = "queryTopContributors"
 
 	// Service-related invoke
-	RegisterService     = "registerService"
-	InitAccount         = "initAccount"
-	InvalidateService   = "invalidateService" // mark whether the service is validated
-	PublishService      = "publishService"    // publish a created service
-	CreateMashup        = "createMashup"      // utilize services to create a new mashup
-	QueryService        = "queryService"
-	EditService         = "editService"
-	QueryServiceByUser  = "queryServiceByUser"
-	QueryServiceByRange = "queryServiceByRange"
-	GivesToken          = "givesToken"
-	InvokeService       = "invokeService"
+	RegisterService                 = "registerService"
+	RegisterServiceBatch            = "registerServiceBatch" // register several services at once
+	InitAccount                     = "initAccount"
+	InvalidateService               = "invalidateService" // mark whether the service is validated
+	PublishService                  = "publishService"    // publish a created service
+	CreateMashup                    = "createMashup"      // utilize services to create a new mashup
+	SimulateMashup                  = "simulateMashup"    // preview a mashup's cost without creating it
+	QueryService                    = "queryService"
+	QueryServiceVersion             = "queryServiceVersion"
+	EditService                     = "editService"
+	QueryServiceByUser              = "queryServiceByUser"
+	QueryServicesByAddress          = "queryServicesByAddress" // services of the user owning an address
+	QueryServiceByRange             = "queryServiceByRange"
+	GetServiceHistory               = "getServiceHistory"
+	QueryServicesByStatus           = "queryServicesByStatus"
+	QueryServicesByType             = "queryServicesByType"
+	QueryServicesRich               = "queryServicesRich" // CouchDB selector query, needs CouchDB
+	SetServiceTags                  = "setServiceTags"
+	BulkAddTag                      = "bulkAddTag"    // tag several of the sender's services at once
+	BulkRemoveTag                   = "bulkRemoveTag" // untag several of the sender's services at once
+	QueryServicesByTag              = "queryServicesByTag"
+	QueryServicesByNames            = "queryServicesByNames"
+	QueryMashupsUsingService        = "queryMashupsUsingService" // mashups compositing a service
+	QueryCoOccurrence               = "queryCoOccurrence"        // services most often composited along with a service
+	QueryServicePaid                = "queryServicePaid"         // buy a DataShareable service's co-occurrence document
+	QueryAccessLog                  = "queryAccessLog"           // who bought a service's co-occurrence document
+	GetUserPortfolio                = "getUserPortfolio"         // a user's profile, services, mashups and earnings
+	QueryServicesGroupedByDeveloper = "queryServicesGroupedByDeveloper"
+	ReconcileMashupComposition      = "reconcileMashupComposition" // check a mashup's composition still resolves
+	GetCompositionGraph             = "getCompositionGraph"        // a mashup's whole composition as a graph
+	QueryChangedSince               = "queryChangedSince"
+	QueryRecentServices             = "queryRecentServices" // the most recently created services
+	QueryServicesCreatedBetween     = "queryServicesCreatedBetween"
+	FinalizeService                 = "finalizeService"   // lock a service's definition for good
+	DeprecateService                = "deprecateService"  // discourage new mashups from compositing a service
+	RevalidateService               = "revalidateService" // make an invalidated service available again
+	SetServicePrice                 = "setServicePrice"
+	SetServicePriority              = "setServicePriority" // promote a service in the discovery ranking
+	RemoveService                   = "removeService"
+	ReclaimService                  = "reclaimService" // free the name of a service never published
+	RegistryDigest                  = "registryDigest" // digest of the users or services for reconciliation
+	Capabilities                    = "capabilities"   // optional features enabled on this deployment
+	GetLimits                       = "getLimits"      // maximum lengths of names and descriptions
+	GetMarketplaceStats             = "getMarketplaceStats"
+	GivesToken                      = "givesToken"
+	InvokeService                   = "invokeService"
+	VoteService                     = "voteService" // thumbs up/down a service
+	CommentService                  = "commentService"
+	QueryServiceComments            = "queryServiceComments"
 
 	// User-related reward invoke
 	RewardService = "rewardService"
 
+	// Admin-related invoke
+	SetConfig                 = "setConfig"
+	SetMashupIncentive        = "setMashupIncentive"
+	SetIncentiveToken         = "setIncentiveToken"
+	SetIncentiveAmount        = "setIncentiveAmount" // amount givesToken pays for an incentive type
+	SetProtocolFee            = "setProtocolFee"     // share of paid invocations taken by the treasury
+	AddAdmin                  = "addAdmin"
+	RemoveAdmin               = "removeAdmin"
+	BanUser                   = "banUser"
+	UnbanUser                 = "unbanUser"
+	InvalidateToken           = "invalidateToken"
+	FindDuplicateDescriptions = "findDuplicateDescriptions" // spot likely spam listings
+	DecayContributions        = "decayContributions"        // decay the contribution of inactive users
+	GrantFromTreasury         = "grantFromTreasury"         // pay out of the treasury, invoked by the treasury
+	QueryTreasuryBalance      = "queryTreasuryBalance"
+	QueryTreasuryLog          = "queryTreasuryLog"
+	SweepStaleDrafts          = "sweepStaleDrafts" // remove services left in S_Created for too long
+	ExportState               = "exportState"      // dump users, services and tokens for a backup
+	ImportState               = "importState"      // restore a dump without overwriting existing records
+
 	Created    string = "created"
 	Delivered  string = "issued"
 	Invalidate string = "invalidated"
 )
 
+// Chaincode events emitted on lifecycle transitions, with a lifecycleEvent
+// payload: {"name": <service or user name>, "developer": <developer>}.
+// developer is the stored service developer, or the address of a new user.
+const (
+	EventServicePublished   = "ServicePublished"
+	EventServiceInvalidated = "ServiceInvalidated"
+	EventServiceDeprecated  = "ServiceDeprecated"
+	EventServiceRevalidated = "ServiceRevalidated"
+	EventMashupCreated      = "MashupCreated"
+	EventUserRegistered     = "UserRegistered"
+)
+
+// Structure definition for a mashup's composition graph: every service
+// reached from the root, and an edge from each mashup to its components.
+// Cycle flags a composition looping back on itself, Truncated a
+// composition deeper than MaxCompositionDepth.
+type compositionGraph struct {
+	Root      string            `json:"root"`
+	Nodes     []compositionNode `json:"nodes"`
+	Edges     []compositionEdge `json:"edges"`
+	Cycle     bool              `json:"cycle"`
+	Truncated bool              `json:"truncated"`
+	seen      map[string]bool   // nodes already added
+}
+
+type compositionNode struct {
+	Name     string `json:"name"`
+	IsMashup bool   `json:"isMashup"`
+	Missing  bool   `json:"missing"` // composited but no longer stored
+}
+
+// userPortfolio is the aggregate returned by getUserPortfolio
+type userPortfolio struct {
+	User           user               `json:"user"`
+	DeveloperToken int                `json:"developerToken"`
+	Contribution   int                `json:"contribution"`
+	Services       []portfolioService `json:"services"`
+	Mashups        []portfolioService `json:"mashups"`
+	Earned         map[string]string  `json:"earned"` // token -> total paid to the user
+}
+
+type portfolioService struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type compositionEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Structure definition for a lifecycle event payload
+type lifecycleEvent struct {
+	Name      string `json:"name"`
+	Developer string `json:"developer"`
+}
+
+// Structure definition for a comment on a service
+type comment struct {
+	Service   string `json:"service"`
+	Commenter string `json:"commenter"` // address of the commenter
+	Content   string `json:"content"`
+	TxId      string `json:"txId"`
+	Time      string `json:"time"`
+}
+
+// Structure definition for a paid access to a service's co-occurrence
+type accessEntry struct {
+	Service string `json:"service"`
+	Payer   string `json:"payer"`  // address of the sender
+	Amount  string `json:"amount"` // INK paid to the developer
+	TxId    string `json:"txId"`
+	Time    string `json:"time"`
+}
+
+// Structure definition for a planned mashup: its resolved composition
+// and what creating it costs its developer
+type mashupPlan struct {
+	Composition      map[string]int
+	ComposedVersions map[string]int
+	Developers       map[string]int      // developers paid an incentive
+	IncentiveToken   string              // token the incentives are paid in
+	Incentive        *big.Int            // incentive paid to each developer
+	Fee              *big.Int            // INK paid to the treasury
+	Totals           map[string]*big.Int // total cost by token
+}
+
+// Structure definition for a treasury ledger entry
+// every movement of the treasury's funds is recorded under
+// TREASURY_<tx timestamp>_<txid>
+type treasuryEntry struct {
+	Seq       int    `json:"seq,omitempty"`  // sequence number of the entries recorded before the txid
+	TxID      string `json:"txId,omitempty"` // transaction that recorded the entry
+	Direction string `json:"direction"`      // TreasuryInflow or TreasuryOutflow
+	Source    string `json:"source"`         // address the funds come from
+	Target    string `json:"target"`         // address the funds go to
+	Amount    string `json:"amount"`
+	Token     string `json:"token"`
+	Reason    string `json:"reason"`
+	Time      string `json:"time"`
+}
+
+// stateSnapshot is the backup written by exportState and read by
+// importState; records are kept verbatim along with their keys
+type stateSnapshot struct {
+	Users    []snapshotEntry `json:"users"`
+	Services []snapshotEntry `json:"services"`
+	Tokens   []snapshotEntry `json:"tokens"`
+}
+
+type snapshotEntry struct {
+	Key    string          `json:"key"`
+	Record json.RawMessage `json:"record"`
+}
+
 // Chaincode for DSES (Decentralized Service Eco-System)
 type serviceChaincode struct {
 }
 
 // Structure definition for user
 type user struct {
-	Name         string `json:"name"`
+	Name         string `json:"name"`        // normalized, see normalizeName
+	DisplayName  string `json:"displayName"` // name as registered
 	Introduction string `json:"introduction"`
 	Address      string `json:"address"`
 	// There is a one-to-one correspondence between "Name" and "Address"
@@ -73,8 +396,18 @@ type user struct {
 
 	Contribution   int `json:"contribution"`
 	DeveloperToken int `json:"developerToken"`
-	// "Contribution" evaluates the user's contribution to the service ecosystem.
-	// TODO: add handler about "Contribution"
+
+	// LastActive records the user's last action; the contribution of an
+	// inactive user decays by DECAY_PERCENT every DECAY_PERIOD days.
+	// DecayedPeriods counts the periods already applied since LastActive.
+	LastActive     string `json:"lastActive"`
+	DecayedPeriods int    `json:"decayedPeriods"`
+
+	// A banned user can't register services, create mashups, vote nor
+	// comment; their existing services stay queryable.
+	Banned bool `json:"banned"`
+	// "Contribution" evaluates the user's contribution to the service ecosystem,
+	// see computeContribution.
 	// Benefit of "Contribution":
 	// 1. construct a evaluation for every user's contribution on the service ecosystem
 	// 2. inspire users to participate in creating new services and mashups
@@ -86,7 +419,7 @@ type Token struct {
 	// token name
 	Name string `json:"tokenName"`
 	// total supply of the token
-	totalSupply *big.Int `json:"totalSupply"`
+	TotalSupply *big.Int `json:"totalSupply"`
 	// initial address to issue
 	Address string `json:"address"`
 	// token status : Created, Delivered, Invalidate
@@ -98,7 +431,8 @@ type Token struct {
 // Structure definition for service
 // type "service" defines conventional services as well as mashups.
 type service struct {
-	Name        string `json:"name"`
+	Name        string `json:"name"`        // normalized, see normalizeName
+	DisplayName string `json:"displayName"` // name as registered
 	Type        string `json:"type"`
 	Developer   string `json:"developer"` // record the user that developed this service
 	Description string `json:"description"`
@@ -114,7 +448,8 @@ type service struct {
 	IsMashup bool `json:"isMashup"`
 
 	// if the service is a mashup, "Composited" records the services that it invokes;
-	// if the service is not a mashup, "Composited" records the co-occurrence documents of the service
+	// if the service is not a mashup, "Composited" records the co-occurrence documents of the service:
+	// how many mashups composited it along with each other service, see recordCoOccurrence
 	Composition map[string]int `json:"composition"`
 
 	// Benefit of "Composited":
@@ -122,6 +457,52 @@ type service struct {
 	// 2. Promote the security and integrality of service data
 
 	// future: people need to pay if they want to use the record information
+
+	// Whether the developer consents to share the service's co-occurrence
+	// documents through queryServicePaid. Only the developer can change it.
+	DataShareable bool `json:"dataShareable"`
+
+	// Once finalized by its developer, a service's definition can never be
+	// changed again; it can still be invalidated.
+	Immutable bool `json:"immutable"`
+
+	// Number of times the service was invoked; records stored before
+	// the count existed start from zero. The stored count only holds
+	// what a rename folded in, the invocations themselves are keys of
+	// the invoke~service~txid index added up on read, see countInvocations.
+	InvokeCount int `json:"invokeCount"`
+
+	// Thumbs up/down votes, one per voter, and the highest net upvote
+	// count already rewarded to the developer.
+	Up            int `json:"up"`
+	Down          int `json:"down"`
+	RewardedVotes int `json:"rewardedVotes"`
+
+	// Version counts the edits of the service; each edit keeps the
+	// previous version under SERVER_VERSION_<name>_<version>.
+	Version int `json:"version"`
+
+	// if the service is a mashup, the version of each composited
+	// service when the mashup was created
+	ComposedVersions map[string]int `json:"composedVersions,omitempty"`
+
+	// if the service is a mashup, the distinct developers it rewarded,
+	// the mashup's own developer excluded
+	ContributorCount int      `json:"contributorCount,omitempty"`
+	Contributors     []string `json:"contributors,omitempty"`
+
+	// Searchable tags, lowercase
+	Tags []string `json:"tags,omitempty"`
+
+	// Placement in the discovery ranking, higher first; set by admins,
+	// 0 unless promoted.
+	Priority int `json:"priority"`
+
+	// Amount of PriceToken the caller of invokeService pays the
+	// developer, "0" for a free service; records stored before the
+	// price existed are read as free, in INK (see migrateServicePrice)
+	Price      string `json:"price"`
+	PriceToken string `json:"priceToken"`
 }
 
 // ===================================================================================
@@ -138,6 +519,54 @@ func main() {
 // ==================================================================================
 func (t *serviceChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("assetChaincode Init.")
+
+	// the deployer of the chaincode becomes the first admin; an upgrade
+	// keeps the admins already set
+	resultsIterator, err := stub.GetStateByRange(AdminPrefix, AdminPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	has_admin := resultsIterator.HasNext()
+	resultsIterator.Close()
+	if !has_admin {
+		admin_add, err := stub.GetSender()
+		if err != nil {
+			return shim.Error("Fail to get the sender's address.")
+		}
+		err = stub.PutState(AdminPrefix+admin_add, []byte(admin_add))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// seed the mashup incentive, keeping the value set before an upgrade
+	incentiveAsBytes, err := stub.GetState(ConfigPrefix + ConfigIncentive)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if incentiveAsBytes == nil {
+		err = stub.PutState(ConfigPrefix+ConfigIncentive, []byte(IncentiveMashupInvoke))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// seed givesToken's amounts, keeping the values set before an upgrade
+	amountsAsBytes, err := stub.GetState(IncentiveAmountsKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amountsAsBytes == nil {
+		amountsAsBytes, err = json.Marshal(defaultIncentiveAmounts)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(IncentiveAmountsKey, amountsAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	return shim.Success([]byte("Init success."))
 }
 
@@ -147,6 +576,11 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	fmt.Println("assetChaincode Invoke.")
 	function, args := stub.GetFunctionAndParameters()
 
+	return checkResponseSize(stub, t.dispatch(stub, function, args))
+}
+
+// dispatch routes an invocation to its handler
+func (t *serviceChaincode) dispatch(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
 	switch function {
 	// ********************************************************
 	// PART 1: User-related invokes
@@ -158,10 +592,11 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return t.registerUser(stub, args)
 
 	case RemoveUser:
-		if len(args) != 1 {
-			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
 		}
 		// args[0]: user name
+		// args[1]: (optional) "true" to invalidate the user's active services first
 		return t.removeUser(stub, args)
 
 	case QueryUser:
@@ -171,6 +606,49 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[0]: user name
 		return t.queryUser(stub, args)
 
+	case QueryUserByAddress:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user's address
+		return t.queryUserByAddress(stub, args)
+
+	case QueryBalance:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.queryBalance(stub, args)
+
+	case UpdateUser:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: user name
+		// args[1]: new introduction
+		return t.updateUser(stub, args)
+
+	case RecalculateContribution:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.recalculateContribution(stub, args)
+
+	case QueryTopContributors:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: number of users
+		return t.queryTopContributors(stub, args)
+
+	case VerifyOwnership:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.verifyOwnership(stub, args)
+
 	case InitAccount:
 		if len(args) != 4 {
 			return shim.Error("Incorrect number of arguments. Expecting 4.")
@@ -181,15 +659,24 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	// ********************************************************
 	// PART 2: service-related invokes
 	case RegisterService:
-		if len(args) != 4 {
-			return shim.Error("Incorrect number of arguments. Expecting 4.")
+		if len(args) != 4 && len(args) != 5 {
+			return shim.Error("Incorrect number of arguments. Expecting 4 or 5.")
 		}
 		// args[0]: service name
 		// args[1]: service type
 		// args[2]: service description
 		// args[3]: developer's name
+		// args[4]: optional, INK price of an invocation
 		return t.registerService(stub, args)
 
+	case RegisterServiceBatch:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: developer's name
+		// args[1]: JSON array of {"name", "type", "description"}
+		return t.registerServiceBatch(stub, args)
+
 	case InvalidateService:
 		if len(args) != 1 {
 			return shim.Error("Incorrect number of arguments. Expecting 1.")
@@ -211,6 +698,14 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[0]: service name
 		return t.queryService(stub, args)
 
+	case QueryServiceVersion:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: version
+		return t.queryServiceVersion(stub, args)
+
 	case EditService:
 		if len(args) != 3 {
 			return shim.Error("Incorrect number of arguments. Expecting 3.")
@@ -230,104 +725,550 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[3...]: invoked service list
 		return t.createMashup(stub, args)
 
+	case SimulateMashup:
+		if len(args) < 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 4 at least.")
+		}
+		// same args as createMashup
+		return t.simulateMashup(stub, args)
+
 	case QueryServiceByRange:
-		if len(args) != 2 {
-			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		if len(args) < 2 || len(args) > 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 2 to 4.")
 		}
 		// args[0]: begin index
 		// args[1]: end index
+		// args[2]: (optional) sortBy, "name", "created", "updated", "trust" or "discovery"
+		// args[3]: (optional) minRating, the lowest net rating (up - down) listed
 		return t.queryServiceByRange(stub, args)
 
-	// ********************************************************
-	// PART 3: user-related reward invokes
-	case RewardService:
-		if len(args) < 3 {
-			return shim.Error("Incorrect number of arguments. Expecting 3 at least.")
+	case GetServiceHistory:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
 		}
 		// args[0]: service name
-		// args[1]: reward_type
-		// args[2]: reward_amount
-		return t.rewardService(stub, args)
+		return t.getServiceHistory(stub, args)
 
-	case GivesToken:
+	case QueryServicesByStatus:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service status
+		return t.queryServicesByStatus(stub, args)
+
+	case QueryServicesByType:
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: service type
+		// args[1]: optional, "prefix" to match the types starting with args[0]
+		return t.queryServicesByType(stub, args)
+
+	case QueryServicesRich:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: CouchDB query, e.g. {"selector":{"status":"available"}}
+		return t.queryServicesRich(stub, args)
+
+	case SetServiceTags:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: comma-separated tags, "" to clear them
+		return t.setServiceTags(stub, args)
+
+	case BulkAddTag:
 		if len(args) < 2 {
 			return shim.Error("Incorrect number of arguments. Expecting 2 at least.")
 		}
-		// args[0]: service name
-		// args[1]: reward_type
-		// args[2]: reward_amount
-		return t.givesToken(stub, args)
+		// args[0]: tag
+		// args[1...]: service names
+		return t.bulkAddTag(stub, args)
 
-	case InvokeService:
+	case BulkRemoveTag:
 		if len(args) < 2 {
 			return shim.Error("Incorrect number of arguments. Expecting 2 at least.")
 		}
-		// args[0]: service name
-		// args[1]: reward_type
-		// args[2]: reward_amount
-		return t.invokeService(stub, args)
-	}
+		// args[0]: tag
+		// args[1...]: service names
+		return t.bulkRemoveTag(stub, args)
 
-	return shim.Error("Invalid invoke function name.")
-}
+	case QueryServicesByTag:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: tag
+		return t.queryServicesByTag(stub, args)
 
-// Invoke func about user
-// ==================================================================================
+	case QueryServiceByUser:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.queryServiceByUser(stub, args)
 
-// ==================================
-// registerUser: Register a new user
-// ==================================
-func (t *serviceChaincode) registerUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var new_name string
-	var new_intro string
-	var new_add string
-	var err error
+	case QueryServicesByAddress:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: developer's address
+		return t.queryServicesByAddress(stub, args)
 
-	new_name = args[0]
-	new_intro = args[1]
+	case QueryServicesByNames:
+		if len(args) < 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 at least.")
+		}
+		// args[0...]: service names
+		return t.queryServicesByNames(stub, args)
 
-	// Get the user's address automatically through INKchian's GetSender() interface
-	new_add, err = stub.GetSender()
-	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
-	}
+	case QueryMashupsUsingService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryMashupsUsingService(stub, args)
 
-	// check if user exists
-	user_key := UserPrefix + new_name
-	userAsBytes, err := stub.GetState(user_key)
-	if err != nil {
-		return shim.Error("Fail to get user: " + err.Error())
-	} else if userAsBytes != nil {
-		return shim.Error("This user already exists: " + new_name)
-	}
+	case QueryCoOccurrence:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryCoOccurrence(stub, args)
 
-	// register user
-	user := &user{new_name, new_intro, new_add, 0, 0}
-	userJSONasBytes, err := json.Marshal(user)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	err = stub.PutState(user_key, userJSONasBytes)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
+	case QueryServicePaid:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryServicePaid(stub, args)
 
-	return shim.Success([]byte("User register & Init account success."))
-}
+	case QueryAccessLog:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryAccessLog(stub, args)
 
-// ==================================
-// initAccount: Initate token for new user accounr
-// ==================================
-func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	// var A string           // Address
-	// var BalanceType string // Token type
+	case GetUserPortfolio:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.getUserPortfolio(stub, args)
 
-	// var err error
+	case QueryServicesGroupedByDeveloper:
+		if len(args) > 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 at most.")
+		}
+		// args[0]: (optional) service status to filter on
+		return t.queryServicesGroupedByDeveloper(stub, args)
 
-	// tokenName := args[1]
+	case ReconcileMashupComposition:
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: mashup name
+		// args[1]: (optional) "true" to remove the unresolved services
+		return t.reconcileMashupComposition(stub, args)
 
-	// A = strings.ToLower(args[0])
-	// BalanceType = args[1]
+	case GetCompositionGraph:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: mashup name
+		return t.getCompositionGraph(stub, args)
+
+	case QueryChangedSince:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: snapshot time, RFC3339 or unix seconds
+		return t.queryChangedSince(stub, args)
+
+	case QueryRecentServices:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: number of services
+		return t.queryRecentServices(stub, args)
+
+	case QueryServicesCreatedBetween:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: start of the window, RFC3339
+		// args[1]: end of the window, RFC3339
+		return t.queryServicesCreatedBetween(stub, args)
+
+	case FinalizeService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.finalizeService(stub, args)
+
+	case DeprecateService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.deprecateService(stub, args)
+
+	case RevalidateService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.revalidateService(stub, args)
+
+	case SetServicePrice:
+		if len(args) != 2 && len(args) != 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 2 or 3.")
+		}
+		// args[0]: service name
+		// args[1]: price of an invocation
+		// args[2]: optional, token the price is paid in, INK by default
+		return t.setServicePrice(stub, args)
+
+	case SetServicePriority:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: priority, higher first in the discovery ranking
+		return t.setServicePriority(stub, args)
+
+	case RemoveService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.removeService(stub, args)
+
+	case ReclaimService:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.reclaimService(stub, args)
+
+	case RegistryDigest:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: scope, "users" or "services"
+		return t.registryDigest(stub, args)
+
+	case Capabilities:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.capabilities(stub, args)
+
+	case GetLimits:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.getLimits(stub, args)
+
+	case GetMarketplaceStats:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.getMarketplaceStats(stub, args)
+
+	// ********************************************************
+	// PART 3: user-related reward invokes
+	case RewardService:
+		if len(args) < 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3 at least.")
+		}
+		// args[0]: service name
+		// args[1]: reward_type
+		// args[2]: reward_amount, in whole tokens, e.g. "1.5"
+		// args[3]: optional, "true" if reward_amount is raw, in the token's smallest unit
+		return t.rewardService(stub, args)
+
+	case GivesToken:
+		if len(args) != 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3.")
+		}
+		// args[0]: reward_type
+		// args[1]: user name of the rewarded developer
+		// args[2]: incentive_type, "1" to "7"
+		return t.givesToken(stub, args)
+
+	case InvokeService:
+		if len(args) < 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 at least.")
+		}
+		// args[0]: service name
+		// args[1...]: unused, still accepted from older clients passing reward_type and reward_amount
+		return t.invokeService(stub, args)
+
+	case VoteService:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: "up" or "down"
+		return t.voteService(stub, args)
+
+	case CommentService:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: comment
+		return t.commentService(stub, args)
+
+	case QueryServiceComments:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryServiceComments(stub, args)
+
+	// ********************************************************
+	// PART 4: admin-related invokes
+	case SetConfig:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: config name
+		// args[1]: config value
+		return t.setConfig(stub, args)
+
+	case SetMashupIncentive:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: incentive amount
+		return t.setMashupIncentive(stub, args)
+
+	case SetIncentiveToken:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: token name
+		return t.setIncentiveToken(stub, args)
+
+	case SetIncentiveAmount:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: incentive type, 1 to 7
+		// args[1]: amount
+		return t.setIncentiveAmount(stub, args)
+
+	case SetProtocolFee:
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: fee in basis points, 0 to 10000
+		// args[1]: (optional) treasury address
+		return t.setProtocolFee(stub, args)
+
+	case AddAdmin:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: address of the new admin
+		return t.addAdmin(stub, args)
+
+	case BanUser:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.setUserBanned(stub, args[0], true)
+
+	case UnbanUser:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.setUserBanned(stub, args[0], false)
+
+	case RemoveAdmin:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: address of the admin
+		return t.removeAdmin(stub, args)
+
+	case SweepStaleDrafts:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.sweepStaleDrafts(stub, args)
+
+	case ExportState:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.exportState(stub, args)
+
+	case ImportState:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: snapshot, as returned by exportState
+		return t.importState(stub, args)
+
+	case InvalidateToken:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: token name
+		return t.invalidateToken(stub, args)
+
+	case FindDuplicateDescriptions:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.findDuplicateDescriptions(stub, args)
+
+	case DecayContributions:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.decayContributions(stub, args)
+
+	case GrantFromTreasury:
+		if len(args) != 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 4.")
+		}
+		// args[0]: receiver's address
+		// args[1]: token type
+		// args[2]: amount
+		// args[3]: reason
+		return t.grantFromTreasury(stub, args)
+
+	case QueryTreasuryBalance:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.queryTreasuryBalance(stub, args)
+
+	case QueryTreasuryLog:
+		if len(args) != 0 {
+			return shim.Error("Incorrect number of arguments. Expecting 0.")
+		}
+		return t.queryTreasuryLog(stub, args)
+	}
+
+	return shim.Error("Invalid invoke function name.")
+}
+
+// Invoke func about user
+// ==================================================================================
+
+// ==================================
+// registerUser: Register a new user
+// ==================================
+func (t *serviceChaincode) registerUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var new_name string
+	var new_intro string
+	var new_add string
+	var err error
+
+	new_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// mashups record their creator's address as developer, a user named
+	// after an address could pass for it
+	if validateAddress(new_name) == nil {
+		return shim.Error("A user name can't be an address: " + new_name)
+	}
+	new_intro = args[1]
+	err = checkLength(stub, ConfigMaxName, "name", new_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkLength(stub, ConfigMaxIntro, "introduction", new_intro)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Get the user's address automatically through INKchian's GetSender() interface
+	new_add, err = stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	err = validateAddress(new_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check if user exists
+	user_key := UserPrefix + new_name
+	userAsBytes, err := stub.GetState(user_key)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes != nil {
+		return shim.Error("This user already exists: " + new_name)
+	}
+
+	// register user
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	user := &user{Name: new_name, DisplayName: strings.TrimSpace(args[0]), Introduction: new_intro,
+		Address: new_add, LastActive: formatTime(tNow)}
+	userJSONasBytes, err := json.Marshal(user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// index the address, the first user registered with it keeps it
+	indexedAsBytes, err := stub.GetState(addressKey(new_add))
+	if err != nil {
+		return shim.Error("Fail to get address index: " + err.Error())
+	}
+	if indexedAsBytes == nil {
+		err = stub.PutState(addressKey(new_add), []byte(new_name))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = emitEvent(stub, EventUserRegistered, new_name, new_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("User register & Init account success."))
+}
+
+// ==================================
+// initAccount: Initate token for new user accounr
+// ==================================
+func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 4); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// var A string           // Address
+	// var BalanceType string // Token type
+
+	// var err error
+
+	// tokenName := args[1]
+
+	// A = strings.ToLower(args[0])
+	// BalanceType = args[1]
 
 	// source_add, err = stub.GetSender()
 	// if err != nil {
@@ -432,7 +1373,16 @@ func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []
 	if !good {
 		return shim.Error("Expecting integer value for totalSupply.")
 	}
-	dec, _ := strconv.Atoi(args[2])
+	if totalSupply.Sign() <= 0 {
+		return shim.Error("Expecting a positive totalSupply: " + args[1])
+	}
+	dec, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Expecting integer value for decimals: " + args[2])
+	}
+	if dec < 0 || dec > MaxTokenDecimals {
+		return shim.Error("Expecting decimals between 0 and " + strconv.Itoa(MaxTokenDecimals) + ": " + args[2])
+	}
 	addr := args[3]
 
 	//Get exist token
@@ -453,7 +1403,7 @@ func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []
 		//create the token
 		existToken.Status = Created
 		existToken.Name = tokenName
-		existToken.totalSupply = totalSupply
+		existToken.TotalSupply = totalSupply
 		existToken.Address = addr
 		existToken.Decimals = dec
 	} else {
@@ -467,14 +1417,19 @@ func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []
 			return shim.Error(msgUnmarshal)
 		}
 		//check the status of token
+		//a delivered or invalidated token can't be issued again
 		if existToken.Status != Created {
-			msgCheckTS := "Token status err, fail to issue token."
+			msgCheckTS := "Token status err, fail to issue token: " + existToken.Status
 			// tralogger.Debug(msgCheckTS)
 			return shim.Error(msgCheckTS)
 		}
+		//legacy records may lack the total supply, take the requested one
+		if existToken.TotalSupply == nil {
+			existToken.TotalSupply = totalSupply
+		}
 		//check the information of token
 		// || existToken.Decimals != dec
-		if existToken.Address != addr || existToken.totalSupply.Cmp(totalSupply) != 0 {
+		if existToken.Address != addr || existToken.TotalSupply.Cmp(totalSupply) != 0 {
 			msgCheckTInfo := "Token info err, check fialed."
 			// tralogger.Debug(msgCheckTInfo)
 			return shim.Error(msgCheckTInfo)
@@ -484,26 +1439,26 @@ func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []
 	//set the token number to address
 
 	//get account of Address
-
-	// account, err := stub.GetAccount(addr)
-	// //check if token has been issued before
-	// if err == nil {
-	// 	if account != nil {
-	// 		if _, ok := account.Balance[tokenName]; ok {
-	// 			msgBalanceCheck := "Token " + tokenName + " already exist in " + addr
-	// 			// tralogger.Debug(msgBalanceCheck)
-	// 			return shim.Error(msgBalanceCheck)
-	// 		}
-	// 	}
-	// }
-	//token hasnot been issued, then
+	account, err := stub.GetAccount(addr)
+	if err != nil {
+		return shim.Error("Fail to get the account of " + addr + ": " + err.Error())
+	}
+	//check if token has been issued before
+	if account != nil {
+		if _, ok := account.Balance[tokenName]; ok {
+			msgBalanceCheck := "Token already issued: " + tokenName + " already exist in " + addr
+			// tralogger.Debug(msgBalanceCheck)
+			return shim.Error(msgBalanceCheck)
+		}
+	}
+	//token hasnot been issued, then
 	//issue token
 	err = stub.Transfer(addr, tokenName, totalSupply)
 	if err != nil {
 		return shim.Error("DSES" + err.Error())
 	}
 
-	// existToken.Status = Delivered
+	existToken.Status = Delivered
 
 	//store the latest status for token in ascc
 	existTokenJson, err := json.Marshal(&existToken)
@@ -524,10 +1479,17 @@ func (t *serviceChaincode) initAccount(stub shim.ChaincodeStubInterface, args []
 // removeUser: Remove an existed user
 // ===================================
 func (t *serviceChaincode) removeUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	var user_name string
 	var err error
 
-	user_name = args[0]
+	user_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// check if user exists
 	user_key := UserPrefix + user_name
@@ -537,12 +1499,96 @@ func (t *serviceChaincode) removeUser(stub shim.ChaincodeStubInterface, args []s
 	} else if userAsBytes == nil {
 		return shim.Error("This user does not exist: " + user_name)
 	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+
+	// only the user or an admin can remove the user
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if !sameAddress(senderAdd, userJSON.Address) {
+		isAdm, err := isAdmin(stub, senderAdd)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !isAdm {
+			return shim.Error("Authority err! Not invoke by the user or an admin.")
+		}
+	}
+
+	force := false
+	if len(args) > 1 {
+		force, err = strconv.ParseBool(args[1])
+		if err != nil {
+			return shim.Error("Error force flag, expecting true or false: " + args[1])
+		}
+	}
+
+	// the user's active services would be left without a developer;
+	// mashups stay with their creator's address
+	services, err := getDeveloperServices(stub, userJSON.Address, &userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var blocking []service
+	for _, serviceJSON := range services {
+		if serviceJSON.Developer == user_name && serviceJSON.Status != S_Invalid {
+			blocking = append(blocking, serviceJSON)
+		}
+	}
+	if len(blocking) > 0 && !force {
+		return shim.Error("The user still owns " + strconv.Itoa(len(blocking)) +
+			" active services, invalidate them first or force the removal: " + user_name)
+	}
+
+	// with force, invalidate the blocking services first; a draft can't
+	// be invalidated and has to be removed instead
+	var invalidated []string
+	for _, serviceJSON := range blocking {
+		err = checkStatusTransition(serviceJSON.Status, S_Invalid)
+		if err != nil {
+			return shim.Error(err.Error() + " Remove it first: " + serviceJSON.Name)
+		}
+		new_service := serviceJSON
+		new_service.Status = S_Invalid
+		serviceJSONasBytes, err := json.Marshal(new_service)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(ServicePrefix+new_service.Name, serviceJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		invalidated = append(invalidated, new_service.Name)
+	}
+	if len(invalidated) > 0 {
+		err = markServicesChanged(stub, invalidated...)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	err = stub.DelState(user_key)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// drop the address index if it points to this user
+	indexedAsBytes, err := stub.GetState(addressKey(userJSON.Address))
+	if err != nil {
+		return shim.Error("Fail to get address index: " + err.Error())
+	}
+	if string(indexedAsBytes) == user_name {
+		err = stub.DelState(addressKey(userJSON.Address))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	return shim.Success([]byte("User delete success."))
 }
 
@@ -550,10 +1596,17 @@ func (t *serviceChaincode) removeUser(stub shim.ChaincodeStubInterface, args []s
 // queryUser: Query an existed user
 // ===================================
 func (t *serviceChaincode) queryUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	var user_name string
 	var err error
 
-	user_name = args[0]
+	user_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// check if user exists
 	user_key := UserPrefix + user_name
@@ -568,505 +1621,469 @@ func (t *serviceChaincode) queryUser(stub shim.ChaincodeStubInterface, args []st
 	return shim.Success(userAsBytes)
 }
 
-// Invoke func about service
-// ==================================================================================
+// ===================================================================
+// queryUserByAddress: query the user registered with an address
+// ===================================================================
+func (t *serviceChaincode) queryUserByAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	address := args[0]
+	err := validateAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	userJSON, err := getUserByAddress(stub, address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if userJSON == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": No user owns this address: " + args[0])
+	}
+
+	userAsBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(userAsBytes)
+}
+
+// ===================================================================
+// queryBalance: query the token balances held by a user's address,
+// amounts as decimal strings; an account that never held any token
+// has no balances
+// ===================================================================
+func (t *serviceChaincode) queryBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
 
-// =======================================
-// registerService: Register a new service
-// =======================================
-func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var service_type string
-	var service_des string
-	var service_dev string
 	var user_name string
 	var err error
 
-	service_name = args[0]
-	service_type = args[1]
-	service_des = args[2]
-	user_name = args[3]
-
-	// get service developer, check if it corresponds with the input user
-	service_dev, err = stub.GetSender()
+	user_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
+		return shim.Error(err.Error())
 	}
-	user_key := UserPrefix + user_name
-	userAsBytes, err := stub.GetState(user_key)
+
+	// STEP 0: resolve the user's address
+	userAsBytes, err := stub.GetState(UserPrefix + user_name)
 	if err != nil {
 		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": This user does not exist: " + user_name)
 	}
 	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	err = json.Unmarshal(userAsBytes, &userJSON)
 	if err != nil {
 		return shim.Error("Error unmarshal user bytes.")
 	}
-	if userJSON.Address != service_dev {
-		return shim.Error("Not the correct user.")
+
+	// STEP 1: read the account
+	account, err := stub.GetAccount(userJSON.Address)
+	if err != nil {
+		return shim.Error("Fail to get the account of " + userJSON.Address + ": " + err.Error())
+	}
+	balances := make(map[string]string)
+	if account != nil {
+		for balance_type, amount := range account.Balance {
+			if amount != nil {
+				balances[balance_type] = amount.String()
+			}
+		}
 	}
 
-	// update developerToken user
-	newtoken := userJSON.DeveloperToken + 1
-	user := &user{userJSON.Name, userJSON.Introduction, userJSON.Address, userJSON.Contribution, newtoken}
-	userJSONasBytes, err := json.Marshal(user)
+	balancesAsBytes, err := json.Marshal(balances)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(user_key, userJSONasBytes)
-	if err != nil {
+	return shim.Success(balancesAsBytes)
+}
+
+// ===================================================================
+// getUserPortfolio: everything about a user in one query: the profile,
+// the services and mashups they developed with their status, and the
+// totals paid to them (incentives, rewards and prices) by token
+// ===================================================================
+func (t *serviceChaincode) getUserPortfolio(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// check if service exists
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	user_name, err := normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes != nil {
-		return shim.Error("This service already exists: " + service_name)
+		return shim.Error(err.Error())
 	}
 
-	// get current time
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
+	// STEP 0: get the user
+	userAsBytes, err := stub.GetState(UserPrefix + user_name)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
 
-	// register service
-	newS := &service{service_name, service_type, user_name,
-		service_des, tString, "", S_Created,
-		false, make(map[string]int)}
-	serviceJSONasBytes, err := json.Marshal(newS)
+	// STEP 1: split what the user developed into services and mashups
+	developed, err := getDeveloperServices(stub, userJSON.Address, &userJSON)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(service_key, serviceJSONasBytes)
+	portfolio := userPortfolio{User: userJSON, DeveloperToken: userJSON.DeveloperToken,
+		Contribution: userJSON.Contribution, Services: []portfolioService{}, Mashups: []portfolioService{}}
+	for _, serviceJSON := range developed {
+		entry := portfolioService{Name: serviceJSON.Name, Type: serviceJSON.Type, Status: serviceJSON.Status}
+		if serviceJSON.IsMashup {
+			portfolio.Mashups = append(portfolio.Mashups, entry)
+		} else {
+			portfolio.Services = append(portfolio.Services, entry)
+		}
+	}
+
+	// STEP 2: the earnings
+	portfolio.Earned, err = getEarnings(stub, user_name)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// result := givesToken(stub, user_name, "INK", "100")
-	// if result != "Ok" {
-	// 	return shim.Error("err.Error()")
-	// }
-
-	return shim.Success([]byte("Service register success."))
+	portfolioAsBytes, err := json.Marshal(portfolio)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(portfolioAsBytes)
 }
 
-// =================================================
-// invalidateService: Invalidate an existed service
-// =================================================
-func (t *serviceChaincode) invalidateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var err error
+// ===================================================================
+// updateUser: change a user's introduction, invoked by the user
+// ===================================================================
+func (t *serviceChaincode) updateUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	service_name = args[0]
+	var user_name string
+	var new_intro string
+	var err error
 
-	// STEP 0: check if service exists
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	user_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes == nil {
-		return shim.Error("This service does not exists: " + service_name)
+		return shim.Error(err.Error())
 	}
-
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
+	new_intro = args[1]
+	err = checkLength(stub, ConfigMaxIntro, "introduction", new_intro)
 	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
+		return shim.Error(err.Error())
 	}
 
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	// check if user exists
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
 	}
-
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
 	if err != nil {
-		return shim.Error("Error get the developer.")
-	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
-
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+		return shim.Error("Error unmarshal user bytes.")
 	}
 
-	// STEP 2: invalidate the service and store it.
-	// new service, make it invalidated
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-		serviceJSON.Description, serviceJSON.CreatedTime, serviceJSON.UpdatedTime,
-		S_Invalid, serviceJSON.IsMashup, serviceJSON.Composition}
-	// store the new service
-	assetJSONasBytes, err := json.Marshal(new_service)
+	// check the sender owns the user
+	sender, err := stub.GetSender()
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if userJSON.Address != sender {
+		return shim.Error("Not the correct user.")
 	}
 
-	err = stub.PutState(service_key, assetJSONasBytes)
+	// update the introduction, keeping the contribution and developer token
+	user := userJSON
+	user.Introduction = new_intro
+	err = touchUser(stub, user)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success([]byte("Invalidate Service success."))
+	return shim.Success([]byte("User update success."))
 }
 
-// =================================================
-// publishService: publish a created service
-// =================================================
-func (t *serviceChaincode) publishService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var err error
+// ===================================================================
+// recalculateContribution: recompute a user's contribution score
+// from the ecosystem's current state
+// ===================================================================
+func (t *serviceChaincode) recalculateContribution(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	service_name = args[0]
+	var user_name string
+	var err error
 
-	// STEP 0: check if service exists
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	user_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes == nil {
-		return shim.Error("This service does not exists: " + service_name)
+		return shim.Error(err.Error())
 	}
 
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
+	// check if user exists
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
 	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
 	}
-
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error("Error unmarshal user bytes.")
 	}
 
-	fmt.Println("SenderAdd:  " + senderAdd)
-	fmt.Println("Developer:  " + serviceJSON.Developer)
-
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	user := userJSON
+	contribution, err := computeContribution(stub, userJSON, nil)
 	if err != nil {
-		return shim.Error("Error get the developer.")
+		return shim.Error(err.Error())
 	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
-
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+	err = setContribution(stub, &user, contribution)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-
-	// STEP 2: publish the service and store it.
-	// new service, make it invalidated
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-		serviceJSON.Description, serviceJSON.CreatedTime, serviceJSON.UpdatedTime,
-		S_Available, serviceJSON.IsMashup, serviceJSON.Composition}
-	// store the new service
-	serviceJSONasBytes, err := json.Marshal(new_service)
+	userJSONasBytes, err := json.Marshal(user)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	err = stub.PutState(service_key, serviceJSONasBytes)
+	err = stub.PutState(user_key, userJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success([]byte("Publish Service success."))
+	resultAsBytes, err := json.Marshal(map[string]interface{}{"name": user_name, "contribution": user.Contribution})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
 }
 
-// ======================================
-// queryService: Query an existed service
-// ======================================
-func (t *serviceChaincode) queryService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var err error
+// ===================================================================
+// queryTopContributors: the users with the highest contribution,
+// ties broken by name; at most MaxTopContributors are returned
+// ===================================================================
+func (t *serviceChaincode) queryTopContributors(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	service_name = args[0]
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 {
+		return shim.Error("Expecting a positive integer for the number of users: " + args[0])
+	}
+	if limit > MaxTopContributors {
+		limit = MaxTopContributors
+	}
 
-	// check if service exists
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	users, err := getAllUsers(stub)
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes == nil {
-		return shim.Error("This service does not exist: " + service_name)
+		return shim.Error(err.Error())
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Contribution != users[j].Contribution {
+			return users[i].Contribution > users[j].Contribution
+		}
+		return users[i].Name < users[j].Name
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	if users == nil {
+		users = []user{}
 	}
 
-	// return service info
-	return shim.Success(serviceAsBytes)
+	usersAsBytes, err := json.Marshal(users)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(usersAsBytes)
 }
 
-// ======================================
-// editService: Edit an existed service
-// ======================================
-func (t *serviceChaincode) editService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var field_name string
-	var field_value string
-	var err error
+// ===================================================================
+// verifyOwnership: tell whether the sender owns a user, as
+// {"owner": true/false}; only a missing user is an error
+// ===================================================================
+func (t *serviceChaincode) verifyOwnership(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	service_name = args[0]
-	field_name = args[1]
-	field_value = args[2]
+	var user_name string
+	var err error
 
-	// STEP 0: check the service does not exist
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	user_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes == nil {
-		return shim.Error("This service does not exist: " + service_name)
+		return shim.Error(err.Error())
 	}
 
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
+	// check if user exists
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
 	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
 	}
-
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error("Error unmarshal user bytes.")
 	}
 
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	sender, err := stub.GetSender()
 	if err != nil {
-		return shim.Error("Error get the developer.")
+		return shim.Error("Fail to get the sender's address.")
 	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
 
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+	resultAsBytes, err := json.Marshal(map[string]bool{"owner": userJSON.Address == sender})
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	return shim.Success(resultAsBytes)
+}
 
-	// STEP 2: update time information
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
-
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-		serviceJSON.Description, serviceJSON.CreatedTime, tString,
-		serviceJSON.Status, serviceJSON.IsMashup, serviceJSON.Composition}
+// Invoke func about service
+// ==================================================================================
 
-	// STEP 3: update field value
-	// developer can update service's type/description information
-	switch field_name {
-	case "Type":
-		new_service.Type = field_value
-		goto LABEL_STORE
-	case "Description":
-		new_service.Description = field_value
-		goto LABEL_STORE
+// =======================================
+// registerService: Register a new service
+// =======================================
+func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 4); err != nil {
+		return shim.Error(err.Error())
 	}
-	return shim.Error("Error field name.")
 
-LABEL_STORE:
-	// STEP 4: store the service
-	serviceJSONasBytes, err := json.Marshal(new_service)
+	var service_name string
+	var service_type string
+	var service_des string
+	var service_dev string
+	var user_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	err = stub.PutState(service_key, serviceJSONasBytes)
+	service_type = args[1]
+	service_des = args[2]
+	user_name, err = normalizeName(args[3])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	// return service info
-	return shim.Success(serviceAsBytes)
-}
-
-// =======================================================
-// createMashup: Create a new mashup
-// note: a mashup should invoke at least one service API
-// =======================================================
-func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var mashup_name string
-	var mashup_type string
-	var mashup_des string
-	var mashup_dev string
-	var err error
-
-	mashup_name = args[0]
-	mashup_type = args[1]
-	mashup_des = args[2]
-
-	// STEP 0: get mashup developer
-	mashup_dev, err = stub.GetSender()
+	err = checkLength(stub, ConfigMaxName, "name", service_name)
 	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
+		return shim.Error(err.Error())
 	}
-
-	// STEP 1: check if service does not exist
-	mashup_key := ServicePrefix + mashup_name
-	serviceAsBytes, err := stub.GetState(mashup_key)
+	err = checkLength(stub, ConfigMaxDesc, "description", service_des)
 	if err != nil {
-		return shim.Error("Fail to get service: " + err.Error())
-	} else if serviceAsBytes != nil {
-		return shim.Error("This service already exists: " + mashup_name)
+		return shim.Error(err.Error())
 	}
-
-	// STEP 2: create a new mashup
-	// get current time
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
-
-	// create composition
-	new_map := make(map[string]int)
-	new_developer_map := make(map[string]int)
-	for i := 3; i < len(args); i++ {
-		// check the service exist
-		service_key := ServicePrefix + args[i]
-		serviceAsBytes, err := stub.GetState(service_key)
-		if err != nil {
-			return shim.Error("Fail to get service: " + err.Error())
-		} else if serviceAsBytes == nil {
-			return shim.Error("This service doesn't exist: " + args[i])
-		}
-		// add the service into map
-		new_map[args[i]] = 1
-		// temporarily store their addresses
-		var serviceJSON service
-		err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	service_price := "0"
+	if len(args) > 4 {
+		service_price = args[4]
+		err = validateNonNegativeBigInt(service_price)
 		if err != nil {
-			return shim.Error("Error unmarshal service bytes.")
+			return shim.Error("Error price, " + err.Error() + ": " + service_price)
 		}
-		new_developer_map[serviceJSON.Developer] = 1
 	}
 
-	// new mashup
-	newS := &service{mashup_name, mashup_type, mashup_dev,
-		mashup_des, tString, "", S_Created,
-		true, new_map}
-
-	// STEP 3: pay to the invoked services' developers
-	// Important!
-	// Incentive Mechanism Here
-
-	incentive_amount := big.NewInt(0)
-	incentive_amount.SetString(IncentiveMashupInvoke, 10)
-
-	for k, _ := range new_developer_map {
-		// get the k's address
-		user_key := UserPrefix + k
-		userAsBytes, err := stub.GetState(user_key)
-		if err != nil {
-			return shim.Error("Fail to get user: " + err.Error())
-		} else if userAsBytes == nil {
-			return shim.Error("This user doesn't exist: " + k)
-		}
-		var userJSON user
-		err = json.Unmarshal([]byte(userAsBytes), &userJSON)
-		if err != nil {
-			return shim.Error("Error unmarshal user bytes.")
-		}
-		// make incentive transfer
-		// from the mashup developer to the invoked service's developer
-		err = stub.Transfer(userJSON.Address, IncentiveBalanceType, incentive_amount)
-		if err != nil {
-			return shim.Error("Error when making transfer.")
-		}
-
-		// update developerToken user
-		newtoken := userJSON.DeveloperToken + 1
-		user := &user{userJSON.Name, userJSON.Introduction, userJSON.Address, userJSON.Contribution, newtoken}
-		userJSONasBytes, err := json.Marshal(user)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		err = stub.PutState(user_key, userJSONasBytes)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
+	// get service developer, check if it corresponds with the input user
+	service_dev, err = stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
 	}
-
-	// STEP 4: store the new mashup
-	serviceJSONasBytes, err := json.Marshal(newS)
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": This user does not exist: " + user_name)
 	}
-	err = stub.PutState(mashup_key, serviceJSONasBytes)
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("Error unmarshal user bytes.")
 	}
-
-	return shim.Success([]byte("Mashup register success."))
-}
-
-// =======================================================
-// rewardService: reward a service
-// reward a service's developer, transfer fixed amount of
-// specific reward_type token to the developer's account.
-// =======================================================
-func (t *serviceChaincode) rewardService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var service_name string
-	var reward_type string
-	var err error
-
-	service_name = args[0]
-	reward_type = args[1]
-
-	// Amount
-	reward_amount := big.NewInt(0)
-	_, good := reward_amount.SetString(args[2], 10)
-	if !good {
-		return shim.Error("Expecting integer value for amount")
+	if userJSON.Address != service_dev {
+		return shim.Error("Not the correct user.")
+	}
+	if userJSON.Banned {
+		return shim.Error("This user is banned: " + user_name)
 	}
 
-	// STEP 0: get service's developer
+	// check if service exists
 	service_key := ServicePrefix + service_name
 	serviceAsBytes, err := stub.GetState(service_key)
 	if err != nil {
-		return shim.Error("Fail to get the service's info.")
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes != nil {
+		return shim.Error("This service already exists: " + service_name)
 	}
 
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	// check the developer's quota of active services
+	err = checkServiceQuota(stub, userJSON.Address, &userJSON, 1)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error(err.Error())
 	}
 
-	dev := serviceJSON.Developer
+	// get current time
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := formatTime(tNow)
 
-	// STEP 1: get the address of the dev
-	user_key := UserPrefix + dev
-	userAsBytes, err := stub.GetState(user_key)
+	// register service
+	newS := &service{Name: service_name, DisplayName: strings.TrimSpace(args[0]), Type: service_type, Developer: user_name,
+		Description: service_des, CreatedTime: tString, UpdatedTime: "", Status: S_Created,
+		IsMashup: false, Composition: make(map[string]int), Price: service_price, PriceToken: IncentiveBalanceType}
+	serviceJSONasBytes, err := json.Marshal(newS)
 	if err != nil {
-		return shim.Error("Fail to get the developer's info.")
+		return shim.Error(err.Error())
 	}
-	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	err = stub.PutState(service_key, serviceJSONasBytes)
 	if err != nil {
-		return shim.Error("Error unmarshal user bytes.")
+		return shim.Error(err.Error())
 	}
-
-	// STEP 3: reward the developer
-	toAdd := userJSON.Address
-	err = stub.Transfer(toAdd, reward_type, reward_amount)
+	err = indexService(stub, *newS)
 	if err != nil {
-		return shim.Error("Fail realize the reawrd.")
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	// update developerToken user
+	// update developerToken user, once the service is stored
 	newtoken := userJSON.DeveloperToken + 1
-	user := &user{userJSON.Name, userJSON.Introduction, userJSON.Address, userJSON.Contribution, newtoken}
+	user := userJSON
+	user.DeveloperToken = newtoken
+	tActive, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	markUserActive(&user, tActive)
+	contribution, err := computeContribution(stub, user, []service{*newS})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setContribution(stub, &user, contribution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	userJSONasBytes, err := json.Marshal(user)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -1076,192 +2093,6620 @@ func (t *serviceChaincode) rewardService(stub shim.ChaincodeStubInterface, args
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success([]byte("Reward the service success."))
+	// no registration reward here: Transfer only moves the sender's funds,
+	// so the developer would pay themselves; another account rewards the
+	// registration through givesToken with incentive type 1
+
+	return shim.Success([]byte("Service register success."))
 }
 
-// ========================================================================
-// queryServiceByRange: query services' names by range (startKey, endKey)
-//
-// startKey and endKey are case-sensitive
-// use "" for both startKey and endKey if you want to query all the assets
-// ========================================================================
-func (t *serviceChaincode) queryServiceByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// ===================================================================
+// registerServiceBatch: register several services of a developer in
+// one transaction; nothing is written if any name is taken
+// ===================================================================
+func (t *serviceChaincode) registerServiceBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	startKey := ""
-	endKey := ""
+	var user_name string
+	var batch []struct {
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	}
+	var err error
 
-	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	user_name, err = normalizeName(args[0])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
+	err = json.Unmarshal([]byte(args[1]), &batch)
+	if err != nil {
+		return shim.Error("Error unmarshal the services: " + err.Error())
+	}
+	if len(batch) == 0 {
+		return shim.Error("Expecting at least one service.")
+	}
+	// the writes and the payout grow with the batch
+	max_batch, err := getConfigInt(stub, ConfigMaxBatch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(batch) > max_batch {
+		return shim.Error("A batch registers " + strconv.Itoa(max_batch) + " services at most, got " + strconv.Itoa(len(batch)) + ".")
+	}
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	// STEP 0: check the sender is the developer
+	service_dev, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+	if userJSON.Address != service_dev {
+		return shim.Error("Not the correct user.")
+	}
+	if userJSON.Banned {
+		return shim.Error("This user is banned: " + user_name)
+	}
 
-	bArrayMemberAlreadyWritten := false
-	bArrayIndex := 1
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	// STEP 1: collect every conflicting name before writing anything
+	var conflicts []string
+	seen := make(map[string]bool)
+	display_names := make(map[string]string)
+	for i := range batch {
+		service_name, err := normalizeName(batch[i].Name)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		err = checkLength(stub, ConfigMaxName, "name", service_name)
+		if err != nil {
+			return shim.Error(err.Error())
 		}
-		// index of the result
-		buffer.WriteString("{\"Number\":")
-		buffer.WriteString("\"")
-		bArrayIndexStr := strconv.Itoa(bArrayIndex)
-		buffer.WriteString(string(bArrayIndexStr))
-		bArrayIndex += 1
-		buffer.WriteString("\"")
-		// information about current asset
-		buffer.WriteString(", \"Record\":")
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		err = checkLength(stub, ConfigMaxDesc, "description", batch[i].Description)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		display_names[service_name] = strings.TrimSpace(batch[i].Name)
+		batch[i].Name = service_name
+	}
+	for _, s := range batch {
+		serviceAsBytes, err := stub.GetState(ServicePrefix + s.Name)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		}
+		if serviceAsBytes != nil || seen[s.Name] {
+			conflicts = append(conflicts, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	if len(conflicts) > 0 {
+		return shim.Error("These services already exist: " + strings.Join(conflicts, ", "))
+	}
+	err = checkServiceQuota(stub, userJSON.Address, &userJSON, len(batch))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
+	// STEP 2: register the services
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := formatTime(tNow)
+	var names []string
+	var registered []service
+	for _, s := range batch {
+		newS := &service{Name: s.Name, DisplayName: display_names[s.Name], Type: s.Type, Developer: user_name,
+			Description: s.Description, CreatedTime: tString, UpdatedTime: "", Status: S_Created,
+			IsMashup: false, Composition: make(map[string]int), Price: "0", PriceToken: IncentiveBalanceType}
+		serviceJSONasBytes, err := json.Marshal(newS)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(ServicePrefix+s.Name, serviceJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = indexService(stub, *newS)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		names = append(names, s.Name)
+		registered = append(registered, *newS)
+	}
+	err = markServicesChanged(stub, names...)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
 
-	return shim.Success(buffer.Bytes())
+	// STEP 3: update developerToken user, one per service
+	user := userJSON
+	user.DeveloperToken = userJSON.DeveloperToken + len(batch)
+	markUserActive(&user, tNow)
+	contribution, err := computeContribution(stub, user, registered)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setContribution(stub, &user, contribution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = touchUser(stub, user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
+	return shim.Success([]byte("Service batch register success."))
 }
 
-// =======================================================
-// givesToken: reward a service
-// reward a service's developer, transfer fixed amount of
-// specific reward_type token to the developer's account.
-// =======================================================
-func (t *serviceChaincode) invokeService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// =================================================
+// invalidateService: Invalidate an existed service
+// =================================================
+func (t *serviceChaincode) invalidateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	var service_name string
-	service_name = args[0]
-	//get developer from service name
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	var err error
+
+	service_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Fail to get the service's info.")
+		return shim.Error(err.Error())
 	}
 
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	// STEP 0: get the service, invoked by its developer or an admin
+	service_key := ServicePrefix + service_name
+	serviceJSON, by_developer, err := requireDeveloperOrAdmin(stub, service_name)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error(err.Error())
 	}
 
-	dev := serviceJSON.Developer
-
-	// STEP 1: get the address of the dev
-	user_key := UserPrefix + dev
-	userAsBytes, err := stub.GetState(user_key)
+	err = checkStatusTransition(serviceJSON.Status, S_Invalid)
 	if err != nil {
-		return shim.Error("Fail to get the developer's info.")
+		return shim.Error(err.Error())
 	}
-	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+
+	// STEP 1: invalidate the service and store it.
+	// new service, make it invalidated
+	new_service := serviceJSON
+	new_service.Status = S_Invalid
+	// store the new service
+	assetJSONasBytes, err := json.Marshal(new_service)
 	if err != nil {
-		return shim.Error("Error unmarshal user bytes.")
+		return shim.Error(err.Error())
 	}
 
-	// update developerToken user
-	newtoken := userJSON.DeveloperToken + 2
-	user := &user{userJSON.Name, userJSON.Introduction, userJSON.Address, userJSON.Contribution, newtoken}
-	userJSONasBytes, err := json.Marshal(user)
+	err = stub.PutState(service_key, assetJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(user_key, userJSONasBytes)
+	err = markServicesChanged(stub, service_name)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if by_developer {
+		err = touchDeveloper(stub, serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
-	return shim.Success([]byte("Reward the service success."))
-	// return "Ok"
+	err = emitEvent(stub, EventServiceInvalidated, service_name, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("Invalidate Service success."))
 }
 
-// =======================================================
-// givesToken: reward a service
-// reward a service's developer, transfer fixed amount of
-// specific reward_type token to the developer's account.
-// =======================================================
-func (t *serviceChaincode) givesToken(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var reward_type string
-	var userName string
-	var incentive_type string
-	var amount string
+// =================================================
+// publishService: publish a created service
+// =================================================
+func (t *serviceChaincode) publishService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
 	var err error
 
-	reward_type = args[0]
-	userName = args[1]
-	incentive_type = args[2]
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	switch incentive_type {
-	// ************************ Developers token ***********************
-	// register service
-	case "1":
-		amount = "110"
-		break
-	// register mashup
-	case "2":
-		amount = "110"
-		break
-	// service is invoked
-	case "3":
-		amount = "110"
-		break
-	// user gives token to service provider
-	case "4":
-		amount = "110"
-		break
+	// STEP 0: get the service, invoked by its developer or an admin
+	service_key := ServicePrefix + service_name
+	serviceJSON, by_developer, err := requireDeveloperOrAdmin(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	// ************************ Users token ***********************
-	// register user
-	case "5":
-		amount = "510"
-		break
-	// comments
-	case "6":
-		amount = "110"
-		break
-	// thumbps up/down (every 10)
-	case "7":
-		amount = "110"
-		break
+	// an invalidated service comes back through revalidateService only
+	if serviceJSON.Status == S_Invalid {
+		return shim.Error("This service is invalidated, revalidate it instead: " + service_name)
+	}
+	err = checkStatusTransition(serviceJSON.Status, S_Available)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
+	// STEP 1: publish the service and store it.
+	// new service, make it invalidated
+	new_service := serviceJSON
+	new_service.Status = S_Available
+	// store the new service
+	serviceJSONasBytes, err := json.Marshal(new_service)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	// Amount
-	reward_amount := big.NewInt(0)
-	_, good := reward_amount.SetString(amount, 10)
-	if !good {
-		return shim.Error("Expecting integer value for amount")
-		// return "Error"
+
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if by_developer {
+		err = touchDeveloper(stub, serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
-	// STEP 1: get the address of the dev
-	user_key := UserPrefix + userName
-	userAsBytes, err := stub.GetState(user_key)
+	err = emitEvent(stub, EventServicePublished, service_name, serviceJSON.Developer)
 	if err != nil {
-		return shim.Error("Fail to get the developer's info.")
-		// return "Error"
+		return shim.Error(err.Error())
 	}
-	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	return shim.Success([]byte("Publish Service success."))
+}
+
+// ========================================================
+// queryServiceVersion: Query a version of an existed service
+// ========================================================
+func (t *serviceChaincode) queryServiceVersion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var version int
+	var err error
+
+	service_name, err = normalizeName(args[0])
 	if err != nil {
-		return shim.Error("Error unmarshal user bytes.")
-		// return "Error"
+		return shim.Error(err.Error())
+	}
+	version, err = strconv.Atoi(args[1])
+	if err != nil || version < 0 {
+		return shim.Error("Expecting a non-negative integer for the version: " + args[1])
 	}
 
-	// STEP 3: reward the developer
-	toAdd := userJSON.Address
-	err = stub.Transfer(toAdd, reward_type, reward_amount)
+	// the current version is the service itself
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
 	if err != nil {
-		return shim.Error("Fail realize the reawrd.")
-		// return "Error"
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if version == serviceJSON.Version {
+		return shim.Success(serviceAsBytes)
 	}
 
-	return shim.Success([]byte("Reward the service success."))
-	// return "Ok"
+	versionAsBytes, err := stub.GetState(versionKey(service_name, version))
+	if err != nil {
+		return shim.Error("Fail to get service version: " + err.Error())
+	} else if versionAsBytes == nil {
+		return shim.Error("This service version does not exist: " + service_name + " " + args[1])
+	}
+	return shim.Success(versionAsBytes)
+}
+
+// ======================================
+// queryService: Query an existed service
+// ======================================
+func (t *serviceChaincode) queryService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+
+	// return service info, with the price of older records filled in
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	migrateServicePrice(&serviceJSON)
+	invocations, err := countInvocations(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.InvokeCount += invocations
+	serviceAsBytes, err = json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(serviceAsBytes)
+}
+
+// ======================================
+// editService: Edit an existed service
+// ======================================
+func (t *serviceChaincode) editService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 3); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var field_name string
+	var field_value string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	field_name = args[1]
+	field_value = args[2]
+
+	// STEP 0: get the service, invoked by its developer
+	service_key := ServicePrefix + service_name
+	serviceJSON, err := requireDeveloper(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Immutable {
+		return shim.Error("This service is finalized and can't be edited: " + service_name)
+	}
+	if serviceJSON.Status == S_Invalid {
+		return shim.Error("This service is invalidated and can't be edited: " + service_name)
+	}
+
+	// STEP 1: update time information
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := formatTime(tNow)
+
+	new_service := serviceJSON
+	new_service.UpdatedTime = tString
+	new_service.Version = serviceJSON.Version + 1
+
+	// STEP 2: update field value
+	// developer can update service's name/type/description/data sharing information,
+	// the price through setServicePrice
+	switch field_name {
+	case "Name":
+		// the name is the state key, the new one must be free
+		new_name, err := normalizeName(field_value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkLength(stub, ConfigMaxName, "name", new_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		takenAsBytes, err := stub.GetState(ServicePrefix + new_name)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		} else if takenAsBytes != nil {
+			return shim.Error("This service already exists: " + new_name)
+		}
+		new_service.Name = new_name
+		new_service.DisplayName = strings.TrimSpace(field_value)
+		goto LABEL_STORE
+	case "Type":
+		new_service.Type = field_value
+		goto LABEL_STORE
+	case "Description":
+		err = checkLength(stub, ConfigMaxDesc, "description", field_value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		new_service.Description = field_value
+		goto LABEL_STORE
+	case "DataShareable":
+		new_service.DataShareable, err = strconv.ParseBool(field_value)
+		if err != nil {
+			return shim.Error("Expecting true or false for DataShareable.")
+		}
+		goto LABEL_STORE
+	}
+	return shim.Error("Error field name.")
+
+LABEL_STORE:
+	// STEP 3: store the service
+	serviceJSONasBytes, err := json.Marshal(new_service)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.PutState(ServicePrefix+new_service.Name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	changed_names := []string{new_service.Name}
+
+	// keep the previous version; a renamed service takes its versions,
+	// access log, invocations, votes and comments along, so that none is
+	// left for a service later registered under the old name
+	if new_service.Name != service_name {
+		err = moveServiceVersions(stub, service_name, new_service.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = moveAccessLog(stub, service_name, new_service.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = moveInvocations(stub, service_name, new_service.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = moveVotes(stub, service_name, new_service.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = moveComments(stub, service_name, new_service.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	serviceAsBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(versionKey(new_service.Name, serviceJSON.Version), serviceAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// on a rename, move the record and follow it in the mashups compositing it
+	// and in the co-occurrence documents of the services used along with it
+	if new_service.Name != service_name {
+		err = stub.DelState(service_key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = unindexService(stub, serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = indexService(stub, new_service)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		changed_names = append(changed_names, service_name)
+
+		// the mashups come from the component~mashup index, the services
+		// used along with it from its own co-occurrence document
+		others, err := getMashupsUsing(stub, service_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !serviceJSON.IsMashup {
+			for _, partner_name := range sortedKeys(serviceJSON.Composition) {
+				partnerAsBytes, err := stub.GetState(ServicePrefix + partner_name)
+				if err != nil {
+					return shim.Error("Fail to get service: " + err.Error())
+				}
+				if partnerAsBytes == nil {
+					continue
+				}
+				var partnerJSON service
+				err = json.Unmarshal(partnerAsBytes, &partnerJSON)
+				if err != nil {
+					return shim.Error("Error unmarshal service bytes: " + partner_name)
+				}
+				if !partnerJSON.IsMashup && partnerJSON.Composition[service_name] != 0 {
+					others = append(others, partnerJSON)
+				}
+			}
+		}
+		for _, other := range others {
+			other.Composition[new_service.Name] = other.Composition[service_name]
+			delete(other.Composition, service_name)
+			if version, ok := other.ComposedVersions[service_name]; ok {
+				other.ComposedVersions[new_service.Name] = version
+				delete(other.ComposedVersions, service_name)
+			}
+			otherAsBytes, err := json.Marshal(other)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = stub.PutState(ServicePrefix+other.Name, otherAsBytes)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if other.IsMashup {
+				err = moveComponentIndex(stub, other.Name, service_name, new_service.Name)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+			changed_names = append(changed_names, other.Name)
+		}
+	}
+
+	err = markServicesChanged(stub, changed_names...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = touchDeveloper(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// return the updated service info
+	return shim.Success(serviceJSONasBytes)
+}
+
+// ===================================================================
+// simulateMashup: run createMashup's checks and report who would be
+// paid and what the mashup would cost, without writing any state nor
+// making any transfer
+// ===================================================================
+func (t *serviceChaincode) simulateMashup(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 4); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mashup_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkLength(stub, ConfigMaxName, "name", mashup_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkLength(stub, ConfigMaxDesc, "description", args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: get mashup developer
+	mashup_dev, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	err = validateAddress(mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotBanned(stub, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: the same checks as createMashup
+	serviceAsBytes, err := stub.GetState(ServicePrefix + mashup_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes != nil {
+		return shim.Error("This service already exists: " + mashup_name)
+	}
+	creatorJSON, err := getIndexedUser(stub, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkServiceQuota(stub, mashup_dev, creatorJSON, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	plan, err := planMashup(stub, mashup_name, mashup_dev, args[3:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: summarize the payouts against the developer's balances
+	summary := struct {
+		Name           string            `json:"name"`
+		Developers     []string          `json:"developers"`
+		IncentiveToken string            `json:"incentiveToken"`
+		Incentive      string            `json:"incentive"`
+		Fee            string            `json:"fee"`
+		Totals         map[string]string `json:"totals"`
+		Balances       map[string]string `json:"balances"`
+		Sufficient     bool              `json:"sufficient"`
+	}{Name: mashup_name, Developers: sortedKeys(plan.Developers), IncentiveToken: plan.IncentiveToken,
+		Incentive: plan.Incentive.String(), Fee: plan.Fee.String(),
+		Totals: make(map[string]string), Balances: make(map[string]string), Sufficient: true}
+	for balance_type, total_amount := range plan.Totals {
+		balance, err := getBalance(stub, mashup_dev, balance_type)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		summary.Totals[balance_type] = total_amount.String()
+		summary.Balances[balance_type] = balance.String()
+		if balance.Cmp(total_amount) < 0 {
+			summary.Sufficient = false
+		}
+	}
+
+	summaryAsBytes, err := json.Marshal(summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(summaryAsBytes)
+}
+
+// =======================================================
+// createMashup: Create a new mashup
+// note: a mashup should invoke at least one service API
+// =======================================================
+func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 4); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var mashup_name string
+	var mashup_type string
+	var mashup_des string
+	var mashup_dev string
+	var err error
+
+	mashup_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mashup_type = args[1]
+	mashup_des = args[2]
+	err = checkLength(stub, ConfigMaxName, "name", mashup_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkLength(stub, ConfigMaxDesc, "description", mashup_des)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: get mashup developer
+	mashup_dev, err = stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	err = validateAddress(mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotBanned(stub, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check if service does not exist
+	mashup_key := ServicePrefix + mashup_name
+	serviceAsBytes, err := stub.GetState(mashup_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes != nil {
+		return shim.Error("This service already exists: " + mashup_name)
+	}
+
+	// check the developer's quota of active services
+	creatorJSON, err := getIndexedUser(stub, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkServiceQuota(stub, mashup_dev, creatorJSON, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: create a new mashup
+	// get current time
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := formatTime(tNow)
+
+	// resolve the composition and what the mashup costs its developer
+	plan, err := planMashup(stub, mashup_name, mashup_dev, args[3:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	new_developer_map := plan.Developers
+	incentive_token, incentive_amount, fee_amount := plan.IncentiveToken, plan.Incentive, plan.Fee
+
+	// new mashup
+	newS := &service{Name: mashup_name, DisplayName: strings.TrimSpace(args[0]), Type: mashup_type, Developer: mashup_dev,
+		Description: mashup_des, CreatedTime: tString, UpdatedTime: "", Status: S_Created,
+		IsMashup: true, Composition: plan.Composition, ComposedVersions: plan.ComposedVersions,
+		ContributorCount: len(new_developer_map), Contributors: sortedKeys(new_developer_map),
+		Price: "0", PriceToken: IncentiveBalanceType}
+
+	// STEP 3: pay to the invoked services' developers
+	// Important!
+	// Incentive Mechanism Here
+	for balance_type, total_amount := range plan.Totals {
+		balance, err := getBalance(stub, mashup_dev, balance_type)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if balance.Cmp(total_amount) < 0 {
+			return shim.Error("Insufficient balance for the mashup: need " + total_amount.String() +
+				" " + balance_type + ", have " + balance.String() + ".")
+		}
+	}
+
+	if fee_amount.Sign() > 0 {
+		treasury, err := getConfig(stub, ConfigTreasury)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if treasury == "" {
+			return shim.Error("The treasury address is not configured.")
+		}
+		err = stub.Transfer(treasury, IncentiveBalanceType, fee_amount)
+		if err != nil {
+			return shim.Error("Error when paying the mashup creation fee.")
+		}
+		err = recordTreasuryEntry(stub, TreasuryInflow, mashup_dev, treasury,
+			fee_amount, IncentiveBalanceType, "mashup creation fee: "+mashup_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// a failed transfer returns before the mashup is stored: the error response
+	// fails the whole transaction, so the payouts already made are never committed
+	for k, _ := range new_developer_map {
+		// get the k's address
+		user_key := UserPrefix + k
+		userAsBytes, err := stub.GetState(user_key)
+		if err != nil {
+			return shim.Error("Fail to get user: " + err.Error())
+		} else if userAsBytes == nil {
+			return shim.Error("This user doesn't exist: " + k)
+		}
+		var userJSON user
+		err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal user bytes.")
+		}
+		// make incentive transfer
+		// from the mashup developer to the invoked service's developer
+		err = stub.Transfer(userJSON.Address, incentive_token, incentive_amount)
+		if err != nil {
+			return shim.Error("Error when paying the incentive to " + k + ", the mashup is not created: " + err.Error())
+		}
+		err = recordEarning(stub, k, incentive_token, incentive_amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// update developerToken user
+		newtoken := userJSON.DeveloperToken + 1
+		user := userJSON
+		user.DeveloperToken = newtoken
+		// the contribution counts the new mashup
+		contribution, err := computeContribution(stub, user, []service{*newS})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = setContribution(stub, &user, contribution)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		userJSONasBytes, err := json.Marshal(user)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(user_key, userJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// STEP 4: store the new mashup
+	serviceJSONasBytes, err := json.Marshal(newS)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(mashup_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = indexService(stub, *newS)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the composited services were used together
+	co_occurred, err := recordCoOccurrence(stub, sortedKeys(plan.Composition))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, append([]string{mashup_name}, co_occurred...)...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, EventMashupCreated, mashup_name, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("Mashup register success."))
+}
+
+// =======================================================
+// rewardService: reward a service
+// reward a service's developer, transfer fixed amount of
+// specific reward_type token to the developer's account.
+// =======================================================
+func (t *serviceChaincode) rewardService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 3); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var reward_type string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	reward_type = args[1]
+
+	raw := false
+	if len(args) > 3 {
+		raw, err = strconv.ParseBool(args[3])
+		if err != nil {
+			return shim.Error("Error raw flag, expecting true or false: " + args[3])
+		}
+	}
+
+	// Amount, scaled by the token's decimals unless given raw;
+	// tokens without a Token record, like INK, aren't scaled
+	decimals := 0
+	if !raw {
+		tokenAsBytes, err := stub.GetState(reward_type)
+		if err != nil {
+			return shim.Error("Fail to get token: " + err.Error())
+		}
+		if tokenAsBytes != nil {
+			var tokenJSON Token
+			err = json.Unmarshal(tokenAsBytes, &tokenJSON)
+			if err != nil {
+				return shim.Error("Error unmarshal token bytes.")
+			}
+			decimals = tokenJSON.Decimals
+		}
+	}
+	reward_amount, err := scaleAmount(args[2], decimals)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: get service's developer
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get the service's info.")
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: get the address of the dev
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if DevJSON == nil {
+		return shim.Error("This developer does not exist: " + serviceJSON.Developer)
+	}
+	userJSON := *DevJSON
+	dev := userJSON.Name
+	user_key := UserPrefix + dev
+
+	// STEP 2: check the developer's account can receive the reward
+	// an account that never held any token yet is fine: the transfer creates it
+	toAdd := userJSON.Address
+	if toAdd == "" {
+		return shim.Error("The developer has no address to receive the reward: " + dev)
+	}
+	_, err = stub.GetAccount(toAdd)
+	if err != nil {
+		return shim.Error("Fail to get the developer's account: " + err.Error())
+	}
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	balance, err := getBalance(stub, senderAdd, reward_type)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if balance.Cmp(reward_amount) < 0 {
+		return shim.Error("Insufficient " + reward_type + " balance for the reward: have " + balance.String() + ".")
+	}
+
+	// STEP 3: reward the developer
+	err = stub.Transfer(toAdd, reward_type, reward_amount)
+	if err != nil {
+		return shim.Error("Fail realize the reawrd: " + err.Error())
+	}
+	err = recordEarning(stub, dev, reward_type, reward_amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// update developerToken user
+	newtoken := userJSON.DeveloperToken + 1
+	user := userJSON
+	user.DeveloperToken = newtoken
+	userJSONasBytes, err := json.Marshal(user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Reward the service success: " + args[2] + " " + reward_type +
+		" (" + reward_amount.String() + " raw)."))
+}
+
+// ========================================================================
+// queryServiceByRange: query services' names by range (startKey, endKey)
+//
+// startKey and endKey are case-sensitive
+// use "" for both startKey and endKey if you want to query all the services
+//
+// results come in key order, which is name order; sorting by "created"
+// or "updated" (never updated services first) buffers every result in
+// memory, so narrow the range on large ledgers. Sorting by "trust"
+// computes each service's trustScore, highest first, and returns it
+// along with the record as TrustScore; "discovery", the marketplace's
+// ranking, orders by Priority first, highest first, then likewise.
+//
+// with minRating, only the services whose net rating (Up - Down) reaches
+// it are listed and numbered; the filter applies within the range, so
+// paging through the services by startKey and endKey keeps it
+// ========================================================================
+func (t *serviceChaincode) queryServiceByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the keys are services' names, scoped to ServicePrefix
+	startKey := ServicePrefix + args[0]
+	endKey := ServicePrefix + args[1]
+	if args[1] == "" {
+		endKey = ServicePrefix + string(utf8.MaxRune)
+	}
+
+	sort_by := "name"
+	if len(args) > 2 {
+		sort_by = args[2]
+	}
+	if sort_by != "name" && sort_by != "created" && sort_by != "updated" && sort_by != "trust" && sort_by != "discovery" {
+		return shim.Error(ERR_BAD_ARGS + ": sortBy must be name, created, updated, trust or discovery: " + sort_by)
+	}
+	by_trust := sort_by == "trust" || sort_by == "discovery"
+	filter_rating := len(args) > 3
+	var min_rating int
+	if filter_rating {
+		var err error
+		min_rating, err = strconv.Atoi(args[3])
+		if err != nil {
+			return shim.Error(ERR_BAD_ARGS + ": minRating must be an integer: " + args[3])
+		}
+	}
+
+	var weights trustWeights
+	var tNow time.Time
+	if by_trust {
+		var err error
+		weights, err = getTrustWeights(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		tNow, err = getTxTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	var records [][]byte
+	var times []time.Time
+	var scores []int
+	var priorities []int
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sort_by == "name" && !filter_rating {
+			records = append(records, queryResponse.Value)
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes: " + queryResponse.Key)
+		}
+		if filter_rating && serviceJSON.Up-serviceJSON.Down < min_rating {
+			continue
+		}
+		records = append(records, queryResponse.Value)
+		if sort_by == "name" {
+			continue
+		}
+		if by_trust {
+			score, err := trustScore(stub, serviceJSON, weights, tNow)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			scores = append(scores, score)
+			priorities = append(priorities, serviceJSON.Priority)
+			continue
+		}
+		tString := serviceJSON.CreatedTime
+		if sort_by == "updated" {
+			tString = serviceJSON.UpdatedTime
+		}
+		tRecord, _ := parseTime(tString)
+		times = append(times, tRecord)
+	}
+
+	// key order already is name order, which also breaks the ties
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	if sort_by != "name" {
+		sort.SliceStable(order, func(i, j int) bool {
+			if sort_by == "discovery" && priorities[order[i]] != priorities[order[j]] {
+				return priorities[order[i]] > priorities[order[j]]
+			}
+			if by_trust {
+				return scores[order[i]] > scores[order[j]]
+			}
+			return times[order[i]].Before(times[order[j]])
+		})
+	}
+
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	bArrayIndex := 1
+	for _, k := range order {
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		// index of the result
+		buffer.WriteString("{\"Number\":")
+		buffer.WriteString("\"")
+		bArrayIndexStr := strconv.Itoa(bArrayIndex)
+		buffer.WriteString(string(bArrayIndexStr))
+		bArrayIndex += 1
+		buffer.WriteString("\"")
+		if scores != nil {
+			buffer.WriteString(", \"TrustScore\":")
+			buffer.WriteString(strconv.Itoa(scores[k]))
+		}
+		// information about current asset
+		buffer.WriteString(", \"Record\":")
+		buffer.WriteString(string(records[k]))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+
+}
+
+// ========================================================================
+// queryServiceByUser: query the services developed by a user
+// ========================================================================
+func (t *serviceChaincode) queryServiceByUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var user_name string
+	var err error
+
+	user_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check if user exists
+	userAsBytes, err := stub.GetState(UserPrefix + user_name)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+
+	developed, err := getDeveloperServices(stub, userJSON.Address, &userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	servicesAsBytes, err := json.Marshal(developed)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(servicesAsBytes)
+}
+
+// ===================================================================
+// queryServicesByAddress: query the services of the user owning an
+// address, for integrations only knowing the wallet address
+// ===================================================================
+func (t *serviceChaincode) queryServicesByAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	address := args[0]
+	err := validateAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: resolve the address to its user
+	userJSON, err := getUserByAddress(stub, address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if userJSON == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": No user owns this address: " + args[0])
+	}
+
+	// STEP 2: collect the user's services
+	developed, err := getDeveloperServices(stub, userJSON.Address, userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	servicesAsBytes, err := json.Marshal(developed)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(servicesAsBytes)
+}
+
+// ===================================================================
+// setServiceTags: replace a service's tags, invoked by its developer
+// ===================================================================
+func (t *serviceChaincode) setServiceTags(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var tags []string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tags, err = parseTags(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if serviceJSON.Immutable {
+		return shim.Error("This service is finalized and can't be edited: " + service_name)
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	sender, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, sender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !owner {
+		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+	}
+
+	// STEP 2: store the tags
+	new_service := serviceJSON
+	new_service.Tags = tags
+	serviceJSONasBytes, err := json.Marshal(new_service)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(serviceJSONasBytes)
+}
+
+// ===================================================================
+// bulkAddTag: add a tag to several of the sender's services at once
+// ===================================================================
+func (t *serviceChaincode) bulkAddTag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return bulkTag(stub, args, true)
+}
+
+// ===================================================================
+// bulkRemoveTag: remove a tag from several of the sender's services at
+// once
+// ===================================================================
+func (t *serviceChaincode) bulkRemoveTag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return bulkTag(stub, args, false)
+}
+
+// ===================================================================
+// bulkTag: add a tag to, or remove it from, several services at once
+//
+// the services the sender doesn't own, finalized ones and those the
+// tag would take over MaxServiceTags are skipped rather than failing the
+// whole invoke; the result tells, per service, whether it was
+// "updated", "unchanged" or "skipped" and why
+// ===================================================================
+func bulkTag(stub shim.ChaincodeStubInterface, args []string, add bool) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tags, err := parseTags(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(tags) != 1 {
+		return shim.Error("Expecting a single tag: " + args[0])
+	}
+	tag := tags[0]
+
+	max_batch, err := getConfigInt(stub, ConfigMaxBatch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args)-1 > max_batch {
+		return shim.Error("A batch tags " + strconv.Itoa(max_batch) + " services at most, got " + strconv.Itoa(len(args)-1) + ".")
+	}
+
+	sender, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+
+	type bulkTagResult struct {
+		Service string `json:"service"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason,omitempty"`
+	}
+	results := []bulkTagResult{}
+	var changed []string
+	seen := make(map[string]bool)
+	for _, arg := range args[1:] {
+		service_name, err := normalizeName(arg)
+		if err != nil {
+			results = append(results, bulkTagResult{arg, "skipped", err.Error()})
+			continue
+		}
+		// a second write of the same service would not see the first one
+		if seen[service_name] {
+			results = append(results, bulkTagResult{service_name, "skipped", "listed twice"})
+			continue
+		}
+		seen[service_name] = true
+
+		// STEP 1: check the service exists and the sender may edit it
+		service_key := ServicePrefix + service_name
+		serviceAsBytes, err := stub.GetState(service_key)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		} else if serviceAsBytes == nil {
+			results = append(results, bulkTagResult{service_name, "skipped", "does not exist"})
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes.")
+		}
+		owner, err := isServiceOwner(stub, serviceJSON, sender)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !owner {
+			results = append(results, bulkTagResult{service_name, "skipped", "not the sender's service"})
+			continue
+		}
+		if serviceJSON.Immutable {
+			results = append(results, bulkTagResult{service_name, "skipped", "finalized"})
+			continue
+		}
+
+		// STEP 2: add or remove the tag
+		var new_tags []string
+		has_tag := false
+		for _, service_tag := range serviceJSON.Tags {
+			if service_tag == tag {
+				has_tag = true
+			} else {
+				new_tags = append(new_tags, service_tag)
+			}
+		}
+		if has_tag == add {
+			results = append(results, bulkTagResult{service_name, "unchanged", ""})
+			continue
+		}
+		if add {
+			if len(serviceJSON.Tags) >= MaxServiceTags {
+				results = append(results, bulkTagResult{service_name, "skipped",
+					fmt.Sprintf("already has %d tags", MaxServiceTags)})
+				continue
+			}
+			new_tags = append(serviceJSON.Tags, tag)
+		}
+		serviceJSON.Tags = new_tags
+
+		// STEP 3: store the service
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(service_key, serviceJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		changed = append(changed, service_name)
+		results = append(results, bulkTagResult{service_name, "updated", ""})
+	}
+
+	err = markServicesChanged(stub, changed...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, err := json.Marshal(results)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultsAsBytes)
+}
+
+// ===================================================================
+// queryServicesByTag: query the services carrying a tag, the tag is
+// matched case-insensitively
+// ===================================================================
+func (t *serviceChaincode) queryServicesByTag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tag := strings.ToLower(strings.TrimSpace(args[0]))
+	if tag == "" {
+		return shim.Error("The tag can't be empty.")
+	}
+
+	services, err := getAllServices(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tagged := []service{}
+	for _, serviceJSON := range services {
+		for _, service_tag := range serviceJSON.Tags {
+			if service_tag == tag {
+				tagged = append(tagged, serviceJSON)
+				break
+			}
+		}
+	}
+
+	servicesAsBytes, err := json.Marshal(tagged)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(servicesAsBytes)
+}
+
+// ========================================================================
+// queryServicesRich: query services with a CouchDB selector query
+//
+// rich queries need CouchDB as the state database; under LevelDB the
+// invoke fails with ERR_RANGE_UNSUPPORTED. Only services are returned,
+// whatever else the selector matches.
+// ========================================================================
+func (t *serviceChaincode) queryServicesRich(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var query string
+	var err error
+
+	query = args[0]
+	var parsed map[string]interface{}
+	err = json.Unmarshal([]byte(query), &parsed)
+	if err != nil || len(parsed) == 0 {
+		return shim.Error("Expecting a non-empty JSON query: " + query)
+	}
+
+	resultsIterator, err := stub.GetQueryResult(query)
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	bArrayIndex := 1
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !strings.HasPrefix(queryResponse.Key, ServicePrefix) {
+			continue
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		// index of the result
+		buffer.WriteString("{\"Number\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(strconv.Itoa(bArrayIndex))
+		bArrayIndex += 1
+		buffer.WriteString("\"")
+		// information about current service
+		buffer.WriteString(", \"Record\":")
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ========================================================================
+// queryServicesByStatus: query the services in a given status
+// ========================================================================
+func (t *serviceChaincode) queryServicesByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var status string
+	var err error
+
+	status = args[0]
+	if !isServiceStatus(status) {
+		return shim.Error("Error status: " + status)
+	}
+
+	resultsIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	bArrayIndex := 1
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes: " + queryResponse.Key)
+		}
+		if serviceJSON.Status != status {
+			continue
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		// index of the result
+		buffer.WriteString("{\"Number\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(strconv.Itoa(bArrayIndex))
+		bArrayIndex += 1
+		buffer.WriteString("\"")
+		// information about current service
+		buffer.WriteString(", \"Record\":")
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ========================================================================
+// queryServicesByType: query the services of a given type, or in
+// "prefix" mode the services whose type starts with it
+// ========================================================================
+func (t *serviceChaincode) queryServicesByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_type string
+	var err error
+
+	service_type = args[0]
+	prefix := false
+	if len(args) > 1 {
+		if args[1] != "prefix" && args[1] != "exact" {
+			return shim.Error("Error match mode, expecting prefix or exact: " + args[1])
+		}
+		prefix = args[1] == "prefix"
+	}
+
+	resultsIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	bArrayIndex := 1
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes: " + queryResponse.Key)
+		}
+		if serviceJSON.Type != service_type && !(prefix && strings.HasPrefix(serviceJSON.Type, service_type)) {
+			continue
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		// index of the result
+		buffer.WriteString("{\"Number\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(strconv.Itoa(bArrayIndex))
+		bArrayIndex += 1
+		buffer.WriteString("\"")
+		// information about current service
+		buffer.WriteString(", \"Record\":")
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ========================================================================
+// getServiceHistory: every modification of a service, oldest first
+//
+// a service that was never written has an empty history
+// ========================================================================
+func (t *serviceChaincode) getServiceHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetHistoryForKey(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing the modifications
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"TxId\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(modification.TxId)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Timestamp\":")
+		buffer.WriteString("\"")
+		tMod := time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos))
+		buffer.WriteString(formatTime(tMod))
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"IsDelete\":")
+		buffer.WriteString(strconv.FormatBool(modification.IsDelete))
+
+		// the value is empty for a delete
+		buffer.WriteString(", \"Value\":")
+		if modification.IsDelete {
+			buffer.WriteString("null")
+		} else {
+			buffer.WriteString(string(modification.Value))
+		}
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===================================================================
+// queryCoOccurrence: query the co-occurrence document of a service,
+// the services composited along with it, most frequent first
+//
+// only the developer or an admin can read it for free; others buy it
+// through queryServicePaid
+// ===================================================================
+func (t *serviceChaincode) queryCoOccurrence(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	service_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if err = requireNotMashup(serviceJSON); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check the sender may read the document
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	by_admin, err := isAdmin(stub, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !owner && !by_admin {
+		return shim.Error("Only the developer or an admin can read the co-occurrence for free, use queryServicePaid: " + service_name)
+	}
+
+	resultAsBytes, err := coOccurrenceDocument(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===================================================================
+// queryServicePaid: buy the co-occurrence document of a service, paying
+// DATA_PRICE INK to its developer
+//
+// the developer must have made the service DataShareable, otherwise the
+// query fails whatever the sender is willing to pay
+// ===================================================================
+func (t *serviceChaincode) queryServicePaid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	service_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists and is shared
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if err = requireNotMashup(serviceJSON); err != nil {
+		return shim.Error(err.Error())
+	}
+	if !serviceJSON.DataShareable {
+		return shim.Error("The developer doesn't share this service's co-occurrence: " + service_name)
+	}
+
+	// STEP 1: pay the developer, unless the developer queries it
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if DevJSON == nil {
+		return shim.Error("This developer does not exist: " + serviceJSON.Developer)
+	}
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	price_config, err := getConfig(stub, ConfigDataPrice)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	price, good := big.NewInt(0).SetString(price_config, 10)
+	if !good {
+		return shim.Error("Config " + ConfigDataPrice + " is not an integer: " + price_config)
+	}
+	if sameAddress(senderAdd, DevJSON.Address) {
+		price = big.NewInt(0)
+	}
+	if price.Sign() > 0 {
+		balance, err := getBalance(stub, senderAdd, IncentiveBalanceType)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if balance.Cmp(price) < 0 {
+			return shim.Error("Insufficient " + IncentiveBalanceType + " balance to query the service: need " +
+				price.String() + ", have " + balance.String() + ".")
+		}
+		err = stub.Transfer(DevJSON.Address, IncentiveBalanceType, price)
+		if err != nil {
+			return shim.Error("Error when paying for the service's data: " + err.Error())
+		}
+		err = recordEarning(stub, DevJSON.Name, IncentiveBalanceType, price)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// STEP 2: log the access for the developer
+	err = recordAccess(stub, service_name, senderAdd, price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := coOccurrenceDocument(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===================================================================
+// queryAccessLog: query who bought a service's co-occurrence document
+// through queryServicePaid, what they paid and when, oldest first
+//
+// only the service's developer can read it
+// ===================================================================
+func (t *serviceChaincode) queryAccessLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	service_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: check the sender is the developer
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !owner {
+		return shim.Error("Only the developer can read the service's access log: " + service_name)
+	}
+
+	entries, err := getAccessLog(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if entries == nil {
+		entries = []accessEntry{}
+	}
+	entriesAsBytes, err := json.Marshal(entries)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(entriesAsBytes)
+}
+
+// coOccurrenceDocument lists the services composited along with a
+// service, most frequent first
+func coOccurrenceDocument(serviceJSON service) ([]byte, error) {
+	type coOccurrence struct {
+		Service string `json:"service"`
+		Count   int    `json:"count"`
+	}
+	co_occurrences := []coOccurrence{}
+	for _, name := range sortedKeys(serviceJSON.Composition) {
+		co_occurrences = append(co_occurrences, coOccurrence{name, serviceJSON.Composition[name]})
+	}
+	sort.SliceStable(co_occurrences, func(i, j int) bool {
+		return co_occurrences[i].Count > co_occurrences[j].Count
+	})
+	return json.Marshal(co_occurrences)
+}
+
+// ===================================================================
+// queryMashupsUsingService: query the mashups compositing a service,
+// for impact analysis before changing it
+// ===================================================================
+func (t *serviceChaincode) queryMashupsUsingService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	service_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mashups, err := getMashupsUsing(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mashupsAsBytes, err := json.Marshal(mashups)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(mashupsAsBytes)
+}
+
+// ===================================================================
+// queryServicesByNames: query several services by their names at once
+//
+// missing services are represented as {"name":..., "found":false}
+// ===================================================================
+func (t *serviceChaincode) queryServicesByNames(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// buffer is a JSON array containing the services' records
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	for i, service_name := range args {
+		service_name, err := normalizeName(service_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		if serviceAsBytes == nil {
+			missingAsBytes, err := json.Marshal(map[string]interface{}{"name": service_name, "found": false})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			buffer.Write(missingAsBytes)
+			continue
+		}
+		buffer.Write(serviceAsBytes)
+	}
+	buffer.WriteString("]")
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===================================================================
+// queryServicesGroupedByDeveloper: query services grouped by developer
+//
+// returns a JSON object keyed by developer name, each value being the
+// developer's services; an optional status restricts the services listed.
+// A mashup is grouped under the user registered with its creator's
+// address, or the address itself if no user is. The groups come in a
+// single response, so at most SCAN_LIMIT services are read and a
+// ledger over the limit fails rather than being cut short.
+// ===================================================================
+func (t *serviceChaincode) queryServicesGroupedByDeveloper(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 at most.")
+	}
+	status := ""
+	if len(args) == 1 && args[0] != "" {
+		status = args[0]
+		if !isServiceStatus(status) {
+			return shim.Error("Error service status: " + status)
+		}
+	}
+	scan_limit, err := getConfigInt(stub, ConfigScanLimit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	groups := make(map[string][]service)
+	creators := make(map[string]string)
+	scanned := 0
+	for resultsIterator.HasNext() {
+		if scanned >= scan_limit {
+			return shim.Error(fmt.Sprintf("%s: more than %d services to group, raise %s to list them.",
+				ERR_RESPONSE_TOO_LARGE, scan_limit, ConfigScanLimit))
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		scanned++
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes.")
+		}
+		if status != "" && serviceJSON.Status != status {
+			continue
+		}
+
+		// mashups record their creator's address as developer
+		developer := serviceJSON.Developer
+		if serviceJSON.IsMashup {
+			creator, found := creators[developer]
+			if !found {
+				creator = developer
+				userJSON, err := getIndexedUser(stub, developer)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+				if userJSON != nil {
+					creator = userJSON.Name
+				}
+				creators[developer] = creator
+			}
+			developer = creator
+		}
+		groups[developer] = append(groups[developer], serviceJSON)
+	}
+
+	groupsAsBytes, err := json.Marshal(groups)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(groupsAsBytes)
+}
+
+// ======================================================================
+// getCompositionGraph: export the composition graph of a mashup, down
+// to the plain services it ultimately composites
+// ======================================================================
+func (t *serviceChaincode) getCompositionGraph(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mashup_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check the mashup exists
+	mashupAsBytes, err := stub.GetState(ServicePrefix + mashup_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if mashupAsBytes == nil {
+		return shim.Error("This service does not exist: " + mashup_name)
+	}
+	var mashupJSON service
+	err = json.Unmarshal(mashupAsBytes, &mashupJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if err = requireMashup(mashupJSON); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: walk the composition
+	graph := &compositionGraph{Root: mashup_name, Nodes: []compositionNode{}, Edges: []compositionEdge{},
+		seen: make(map[string]bool)}
+	err = walkComposition(stub, graph, mashupJSON, map[string]bool{}, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	graphAsBytes, err := json.Marshal(graph)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(graphAsBytes)
+}
+
+// ======================================================================
+// reconcileMashupComposition: check every service in a mashup's
+// composition still exists, optionally removing the ones that don't.
+// A renamed service never shows up here, editService renames it in the
+// compositing mashups at once, so the only issue is a missing one.
+// Only the mashup's developer or an admin can invoke it.
+// ======================================================================
+func (t *serviceChaincode) reconcileMashupComposition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var mashup_name string
+	var fix bool
+	var err error
+
+	mashup_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) == 2 {
+		fix, err = strconv.ParseBool(args[1])
+		if err != nil {
+			return shim.Error("Expecting true or false for the fix flag.")
+		}
+	}
+
+	// STEP 0: check if mashup exists
+	mashup_key := ServicePrefix + mashup_name
+	mashupAsBytes, err := stub.GetState(mashup_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if mashupAsBytes == nil {
+		return shim.Error("This service does not exist: " + mashup_name)
+	}
+	var mashupJSON service
+	err = json.Unmarshal(mashupAsBytes, &mashupJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	err = requireMashup(mashupJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check whether it is the developer's or an admin's invocation
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	isOwner, err := isServiceOwner(stub, mashupJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isOwner {
+		isAdm, err := isAdmin(stub, senderAdd)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !isAdm {
+			return shim.Error("Authority err! Not invoke by the mashup's developer or an admin.")
+		}
+	}
+
+	// STEP 2: look for the composed services that no longer resolve
+	type reconcileAction struct {
+		Service string `json:"service"`
+		Issue   string `json:"issue"`
+		Action  string `json:"action"`
+	}
+	actions := []reconcileAction{}
+	new_map := make(map[string]int)
+	for _, k := range sortedKeys(mashupJSON.Composition) {
+		serviceAsBytes, err := stub.GetState(ServicePrefix + k)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		}
+		if serviceAsBytes != nil {
+			new_map[k] = mashupJSON.Composition[k]
+			continue
+		}
+		action := "flagged"
+		if fix {
+			action = "removed"
+		}
+		actions = append(actions, reconcileAction{k, "missing", action})
+	}
+
+	// STEP 3: store the reconciled mashup
+	if fix && len(actions) > 0 {
+		if mashupJSON.Immutable {
+			return shim.Error("This service is finalized and can't be edited: " + mashup_name)
+		}
+		tNow, err := getTxTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		mashupJSON.Composition = new_map
+		for _, action := range actions {
+			delete(mashupJSON.ComposedVersions, action.Service)
+		}
+		mashupJSON.UpdatedTime = formatTime(tNow)
+		mashupJSONasBytes, err := json.Marshal(mashupJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(mashup_key, mashupJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, action := range actions {
+			err = moveComponentIndex(stub, mashup_name, action.Service, "")
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		err = markServicesChanged(stub, mashup_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	actionsAsBytes, err := json.Marshal(actions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(actionsAsBytes)
+}
+
+// ===================================================================
+// removeService: delete a service, invoked by its developer
+//
+// a service still composited by a mashup is kept, so that no
+// composition is left dangling; its votes, comments and past versions
+// are deleted along with it, see purgeServiceData
+// ===================================================================
+func (t *serviceChaincode) removeService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exists: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	sender, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, sender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !owner {
+		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+	}
+
+	// STEP 2: refuse while mashups still composite the service
+	dependents, err := getMashupsUsing(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(dependents) > 0 {
+		return shim.Error("The service is still composited by " + strconv.Itoa(len(dependents)) + " mashups: " + service_name)
+	}
+
+	// STEP 3: delete the service
+	err = stub.DelState(service_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = unindexService(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	purged, err := purgeServiceData(stub, []service{serviceJSON})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, append([]string{service_name}, purged...)...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Remove service success."))
+}
+
+// ===================================================================
+// reclaimService: delete a service left in S_Created for longer than
+// DRAFT_TTL days, invoked by anyone, so that unpublished services
+// can't squat a name; the refusal tells how long until it expires
+// ===================================================================
+func (t *serviceChaincode) reclaimService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: only services never published expire
+	if serviceJSON.Status != S_Created {
+		return shim.Error("Only services still in status " + S_Created + " can be reclaimed: " + service_name)
+	}
+	ttl_days, err := getConfigInt(stub, ConfigDraftTTL)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tCreated, err := parseTime(serviceJSON.CreatedTime)
+	if err != nil {
+		return shim.Error("Fail to parse the service's created time: " + serviceJSON.CreatedTime)
+	}
+	tExpiry := tCreated.Add(time.Duration(ttl_days) * 24 * time.Hour)
+	if tNow.Before(tExpiry) {
+		return shim.Error("The service can't be reclaimed before " + formatTime(tExpiry) +
+			", in " + tExpiry.Sub(tNow).String() + ": " + service_name)
+	}
+
+	// STEP 2: refuse while mashups still composite the service
+	dependents, err := getMashupsUsing(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(dependents) > 0 {
+		return shim.Error("The service is still composited by " + strconv.Itoa(len(dependents)) + " mashups: " + service_name)
+	}
+
+	// STEP 3: delete the service, freeing its name
+	err = stub.DelState(service_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = unindexService(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	purged, err := purgeServiceData(stub, []service{serviceJSON})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, append([]string{service_name}, purged...)...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Reclaim service success."))
+}
+
+// ===================================================================
+// finalizeService: lock an available service's definition for good
+// ===================================================================
+func (t *serviceChaincode) finalizeService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	isOwner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isOwner {
+		return shim.Error("Authority err! Not invoke by the service's developer.")
+	}
+
+	// STEP 2: only an available service not finalized yet can be finalized
+	if serviceJSON.Immutable {
+		return shim.Error("This service is already finalized: " + service_name)
+	}
+	if serviceJSON.Status != S_Available {
+		return shim.Error("Only an available service can be finalized, current status: " + serviceJSON.Status)
+	}
+
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.Immutable = true
+	serviceJSON.UpdatedTime = formatTime(tNow)
+
+	// STEP 3: store the service
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Finalize Service success."))
+}
+
+// ===================================================================
+// deprecateService: mark an available service as deprecated, it keeps
+// working but new mashups can't composite it unless
+// ALLOW_DEPRECATED_COMPOSITION is set
+// ===================================================================
+func (t *serviceChaincode) deprecateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	isOwner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isOwner {
+		return shim.Error("Authority err! Not invoke by the service's developer.")
+	}
+
+	// STEP 2: only an available service can be deprecated
+	err = checkStatusTransition(serviceJSON.Status, S_Deprecated)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.Status = S_Deprecated
+	serviceJSON.UpdatedTime = formatTime(tNow)
+
+	// STEP 3: store the service
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, EventServiceDeprecated, service_name, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("Deprecate Service success."))
+}
+
+// ===================================================================
+// revalidateService: make an invalidated service available again,
+// invoked by its developer; the rest of the service is kept as is
+// ===================================================================
+func (t *serviceChaincode) revalidateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: get the service, invoked by its developer
+	serviceJSON, err := requireDeveloper(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: only an invalidated service can be revalidated
+	if serviceJSON.Status != S_Invalid {
+		return shim.Error("This service is not invalidated: " + service_name)
+	}
+	err = checkStatusTransition(serviceJSON.Status, S_Available)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.Status = S_Available
+	serviceJSON.UpdatedTime = formatTime(tNow)
+
+	// STEP 2: store the service
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = touchDeveloper(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, EventServiceRevalidated, service_name, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("Revalidate Service success."))
+}
+
+// ===================================================================
+// setServicePrice: update the price the callers of invokeService pay
+// the service's developer, and the token it is paid in
+// ===================================================================
+func (t *serviceChaincode) setServicePrice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	service_price := args[1]
+	err = validateNonNegativeBigInt(service_price)
+	if err != nil {
+		return shim.Error("Error price, " + err.Error() + ": " + service_price)
+	}
+	price_token := IncentiveBalanceType
+	if len(args) > 2 {
+		price_token = args[2]
+	}
+	err = checkToken(stub, price_token)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if serviceJSON.Immutable {
+		return shim.Error("This service is finalized and its price can't be changed: " + service_name)
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	isOwner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isOwner {
+		return shim.Error("Authority err! Not invoke by the service's developer.")
+	}
+
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.Price = service_price
+	serviceJSON.PriceToken = price_token
+	serviceJSON.UpdatedTime = formatTime(tNow)
+
+	// STEP 2: store the service
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Set Service price success."))
+}
+
+// ===================================================================
+// setServicePriority: set a service's placement in the discovery
+// ranking of queryServiceByRange, invoked by an admin
+//
+// the priority is curation rather than part of the service's
+// definition: it applies to finalized services too and leaves the
+// version and UpdatedTime alone
+// ===================================================================
+func (t *serviceChaincode) setServicePriority(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	service_name, err := normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	priority, err := strconv.Atoi(args[1])
+	if err != nil {
+		return shim.Error("Expecting an integer for the priority: " + args[1])
+	}
+
+	// STEP 0: check the invocation comes from an admin
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 2: store the service
+	serviceJSON.Priority = priority
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Set Service priority success."))
+}
+
+// ===================================================================
+// registryDigest: fold a running hash over every user or service in key
+// order, so that two systems can cheaply compare their registries
+//
+// digest_n = sha256(digest_n-1 || key || canonical record)
+//
+// the canonical record is the record decoded and marshalled again,
+// which json.Marshal does deterministically: struct fields in
+// declaration order, map keys sorted. A digest of part of the registry
+// would compare wrongly, so a registry over SCAN_LIMIT records fails.
+// ===================================================================
+func (t *serviceChaincode) registryDigest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var scope string
+	var prefix string
+
+	scope = args[0]
+	switch scope {
+	case "users":
+		prefix = UserPrefix
+	case "services":
+		prefix = ServicePrefix
+	default:
+		return shim.Error("Error scope, expecting users or services: " + scope)
+	}
+	scan_limit, err := getConfigInt(stub, ConfigScanLimit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	digest := make([]byte, sha256.Size)
+	count := 0
+	for resultsIterator.HasNext() {
+		if count >= scan_limit {
+			return shim.Error(fmt.Sprintf("%s: more than %d %s to digest, raise %s to digest them.",
+				ERR_RESPONSE_TOO_LARGE, scan_limit, scope, ConfigScanLimit))
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var canonical []byte
+		if scope == "users" {
+			var userJSON user
+			err = json.Unmarshal(queryResponse.Value, &userJSON)
+			if err == nil {
+				canonical, err = json.Marshal(userJSON)
+			}
+		} else {
+			var serviceJSON service
+			err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+			if err == nil {
+				canonical, err = json.Marshal(serviceJSON)
+			}
+		}
+		if err != nil {
+			return shim.Error("Error canonicalize record: " + queryResponse.Key)
+		}
+
+		h := sha256.New()
+		h.Write(digest)
+		h.Write([]byte(queryResponse.Key))
+		h.Write(canonical)
+		digest = h.Sum(nil)
+		count++
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]interface{}{
+		"scope":  scope,
+		"digest": hex.EncodeToString(digest),
+		"count":  count,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===================================================================
+// getMarketplaceStats: count the users, the services by status and
+// the mashups, in a single pass over each prefix
+// ===================================================================
+func (t *serviceChaincode) getMarketplaceStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	users, err := getAllUsers(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	services, err := getAllServices(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	by_status := map[string]int{S_Created: 0, S_Available: 0, S_Invalid: 0, S_Deprecated: 0}
+	mashups := 0
+	for _, s := range services {
+		by_status[s.Status]++
+		if s.IsMashup {
+			mashups++
+		}
+	}
+
+	revenue, err := getRevenue(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	statsAsBytes, err := json.Marshal(map[string]interface{}{
+		"users":    len(users),
+		"services": len(services),
+		"byStatus": by_status,
+		"mashups":  mashups,
+		"revenue":  revenue,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(statsAsBytes)
+}
+
+// ===================================================================
+// capabilities: report which optional features this deployment offers,
+// so that clients can adapt to it
+//
+// config-driven features are enabled by their setConfig parameters,
+// rangeQuery and richQuery are probed against the state database;
+// privateData, staking and subscriptions aren't offered by this chaincode
+// ===================================================================
+func (t *serviceChaincode) capabilities(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	features := map[string]bool{
+		"privateData":   false,
+		"staking":       false,
+		"subscriptions": false,
+	}
+
+	// STEP 1: features switched on through the config registry
+	mashup_fee, err := getConfig(stub, ConfigMashupFee)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fee, good := big.NewInt(0).SetString(mashup_fee, 10)
+	if !good {
+		return shim.Error("Config " + ConfigMashupFee + " is not an integer: " + mashup_fee)
+	}
+	features["mashupFee"] = fee.Sign() > 0
+
+	treasury, err := getConfig(stub, ConfigTreasury)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	features["treasury"] = treasury != ""
+
+	for feature, config_name := range map[string]string{
+		"contributionDecay": ConfigDecayPct,
+		"serviceQuota":      ConfigQuota,
+		"draftSweep":        ConfigDraftTTL,
+	} {
+		value, err := getConfigInt(stub, config_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		features[feature] = value > 0
+	}
+
+	// STEP 2: features depending on the state database
+	rangeIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix)
+	features["rangeQuery"] = err == nil
+	if err == nil {
+		rangeIterator.Close()
+	}
+	richIterator, err := stub.GetQueryResult("{\"selector\":{\"name\":\"\"},\"limit\":1}")
+	features["richQuery"] = err == nil
+	if err == nil {
+		richIterator.Close()
+	}
+
+	featuresAsBytes, err := json.Marshal(features)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(featuresAsBytes)
+}
+
+// ===================================================================
+// getLimits: report the maximum lengths, in characters, enforced on
+// names, descriptions and introductions, and the maximum number of
+// services in a mashup's composition and in a registered batch
+// ===================================================================
+func (t *serviceChaincode) getLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	limits := make(map[string]int)
+	for field, config_name := range map[string]string{
+		"name":         ConfigMaxName,
+		"description":  ConfigMaxDesc,
+		"introduction": ConfigMaxIntro,
+		"composition":  ConfigMaxComp,
+		"batch":        ConfigMaxBatch,
+	} {
+		limit, err := getConfigInt(stub, config_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		limits[field] = limit
+	}
+
+	limitsAsBytes, err := json.Marshal(limits)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(limitsAsBytes)
+}
+
+// ========================================================================
+// queryRecentServices: query the N most recently created services,
+// newest first, through the service~invtime index
+//
+// services created before the index existed aren't listed
+// ========================================================================
+func (t *serviceChaincode) queryRecentServices(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count <= 0 {
+		return shim.Error("Expecting a positive integer for the number of services: " + args[0])
+	}
+
+	// STEP 1: the index is sorted newest first, stop after count services
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ServiceTimeIndex, []string{})
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	recent := []service{}
+	for resultsIterator.HasNext() && len(recent) < count {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) != 2 {
+			return shim.Error("Malformed index key: " + queryResponse.Key)
+		}
+
+		// STEP 2: load the service
+		serviceAsBytes, err := stub.GetState(ServicePrefix + attributes[1])
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		} else if serviceAsBytes == nil {
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes: " + attributes[1])
+		}
+		recent = append(recent, serviceJSON)
+	}
+
+	servicesAsBytes, err := json.Marshal(recent)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(servicesAsBytes)
+}
+
+// ========================================================================
+// queryServicesCreatedBetween: query the services created within a time
+// window, both ends included
+//
+// services whose created time can't be parsed are skipped and listed
+// apart, so the caller can tell they were left out
+// ========================================================================
+func (t *serviceChaincode) queryServicesCreatedBetween(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tFrom, err := parseTimeArg(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tTo, err := parseTimeArg(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if tTo.Before(tFrom) {
+		return shim.Error("The end of the window is before its start: " + args[0] + " - " + args[1])
+	}
+
+	services, err := getAllServices(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	created := []service{}
+	skipped := []string{}
+	for _, serviceJSON := range services {
+		tCreated, err := parseTime(serviceJSON.CreatedTime)
+		if err != nil {
+			skipped = append(skipped, serviceJSON.Name)
+			continue
+		}
+		if tCreated.Before(tFrom) || tCreated.After(tTo) {
+			continue
+		}
+		created = append(created, serviceJSON)
+	}
+
+	servicesAsBytes, err := json.Marshal(map[string]interface{}{"services": created, "skipped": skipped})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(servicesAsBytes)
+}
+
+// ========================================================================
+// queryChangedSince: query the services changed since a snapshot time
+//
+// the candidates are the first RECENT_SIZE services the changed~time~txid~name
+// index holds after the snapshot; each one's history tells whether it
+// was created, updated or deleted after the snapshot
+// ========================================================================
+func (t *serviceChaincode) queryChangedSince(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	since, err := parseTimeArg(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	candidates, err := getChangedSince(stub, since)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type serviceChange struct {
+		Name   string          `json:"name"`
+		Change string          `json:"change"`
+		Record json.RawMessage `json:"record,omitempty"`
+	}
+	changes := []serviceChange{}
+	for _, service_name := range candidates {
+		historyIterator, err := stub.GetHistoryForKey(ServicePrefix + service_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// find the latest modification overall and the latest one at the snapshot
+		var latest, atSnapshot time.Time
+		var latestDelete, existedAtSnapshot, changed bool
+		var latestValue []byte
+		for historyIterator.HasNext() {
+			modification, err := historyIterator.Next()
+			if err != nil {
+				historyIterator.Close()
+				return shim.Error(err.Error())
+			}
+			tMod := time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos))
+			if tMod.After(since) {
+				changed = true
+			} else if !tMod.Before(atSnapshot) {
+				atSnapshot = tMod
+				existedAtSnapshot = !modification.IsDelete
+			}
+			if !tMod.Before(latest) {
+				latest = tMod
+				latestDelete = modification.IsDelete
+				latestValue = modification.Value
+			}
+		}
+		historyIterator.Close()
+
+		if !changed {
+			continue
+		}
+		change := serviceChange{Name: service_name}
+		switch {
+		case latestDelete:
+			change.Change = "deleted"
+		case existedAtSnapshot:
+			change.Change = "updated"
+			change.Record = latestValue
+		default:
+			change.Change = "created"
+			change.Record = latestValue
+		}
+		changes = append(changes, change)
+	}
+
+	changesAsBytes, err := json.Marshal(changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(changesAsBytes)
+}
+
+// =======================================================
+// givesToken: reward a service
+// reward a service's developer, transfer fixed amount of
+// specific reward_type token to the developer's account.
+// =======================================================
+func (t *serviceChaincode) invokeService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//get developer from service name
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get the service's info.")
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: get the address of the dev
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if DevJSON == nil {
+		return shim.Error("This developer does not exist: " + serviceJSON.Developer)
+	}
+	userJSON := *DevJSON
+	dev := userJSON.Name
+	user_key := UserPrefix + dev
+
+	// pay the service's price to the developer, unless the developer invokes it
+	migrateServicePrice(&serviceJSON)
+	price := big.NewInt(0)
+	price.SetString(serviceJSON.Price, 10)
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if price.Sign() > 0 && senderAdd != userJSON.Address {
+		balance, err := getBalance(stub, senderAdd, serviceJSON.PriceToken)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if balance.Cmp(price) < 0 {
+			return shim.Error("Insufficient " + serviceJSON.PriceToken + " balance to invoke the service: need " +
+				price.String() + ", have " + balance.String() + ".")
+		}
+
+		// the treasury takes PROTOCOL_FEE_BPS of the price, the developer the rest
+		fee_bps, err := getConfigInt(stub, ConfigFeeBps)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		fee := big.NewInt(0).Mul(price, big.NewInt(int64(fee_bps)))
+		fee.Div(fee, big.NewInt(BasisPoints))
+		if fee.Sign() > 0 {
+			treasury, err := getConfig(stub, ConfigTreasury)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if treasury == "" {
+				return shim.Error("The treasury address is not configured.")
+			}
+			err = stub.Transfer(treasury, serviceJSON.PriceToken, fee)
+			if err != nil {
+				return shim.Error("Error when paying the protocol fee: " + err.Error())
+			}
+			err = recordTreasuryEntry(stub, TreasuryInflow, senderAdd, treasury,
+				fee, serviceJSON.PriceToken, "protocol fee: "+service_name)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = recordRevenue(stub, serviceJSON.PriceToken, fee)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		dev_share := big.NewInt(0).Sub(price, fee)
+		if dev_share.Sign() > 0 {
+			err = stub.Transfer(userJSON.Address, serviceJSON.PriceToken, dev_share)
+			if err != nil {
+				return shim.Error("Error when paying the service's price: " + err.Error())
+			}
+			err = recordEarning(stub, dev, serviceJSON.PriceToken, dev_share)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	// update developerToken user
+	newtoken := userJSON.DeveloperToken + 2
+	user := userJSON
+	user.DeveloperToken = newtoken
+	userJSONasBytes, err := json.Marshal(user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: count the invocation, leaving the service record alone
+	err = recordInvocation(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Reward the service success."))
+	// return "Ok"
+}
+
+// =======================================================
+// voteService: thumbs up or down a service, once per voter
+//
+// every VotesPerIncentive net upvotes, the voter reaching
+// the count gives the developer the thumbs up incentive
+// =======================================================
+func (t *serviceChaincode) voteService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var direction string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	direction = args[1]
+	if direction != "up" && direction != "down" {
+		return shim.Error("Error direction, expecting up or down: " + direction)
+	}
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if serviceJSON.Status == S_Invalid {
+		return shim.Error("This service is invalidated: " + service_name)
+	}
+
+	// STEP 1: check the voter is not the developer and hasn't voted yet
+	voter, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	err = validateAddress(voter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotBanned(stub, voter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, voter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner {
+		return shim.Error("The developer can't vote for the service.")
+	}
+	vote_key, err := stub.CreateCompositeKey(VoteIndex, []string{service_name, voter})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	voteAsBytes, err := stub.GetState(vote_key)
+	if err != nil {
+		return shim.Error("Fail to get vote: " + err.Error())
+	} else if voteAsBytes != nil {
+		return shim.Error("Already voted for the service: " + service_name)
+	}
+	err = stub.PutState(vote_key, []byte(direction))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: count the vote
+	new_service := serviceJSON
+	if direction == "up" {
+		new_service.Up++
+	} else {
+		new_service.Down++
+	}
+
+	// STEP 3: reward the developer on every VotesPerIncentive net upvotes;
+	// mashups record an address rather than a user, which givesToken can't resolve
+	net_votes := new_service.Up - new_service.Down
+	if net_votes > new_service.RewardedVotes && net_votes%VotesPerIncentive == 0 && !new_service.IsMashup {
+		response := t.givesToken(stub, []string{IncentiveBalanceType, new_service.Developer, "7"})
+		if response.Status != shim.OK {
+			return shim.Error("Fail to reward the developer: " + response.Message)
+		}
+		new_service.RewardedVotes = net_votes
+	}
+
+	serviceJSONasBytes, err := json.Marshal(new_service)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Vote the service success."))
+}
+
+// =======================================================
+// commentService: comment on a service, the commenter
+// gives the developer the comment incentive
+// =======================================================
+func (t *serviceChaincode) commentService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var content string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	content = args[1]
+	if strings.TrimSpace(content) == "" {
+		return shim.Error("The comment can't be empty.")
+	}
+	if utf8.RuneCountInString(content) > MaxCommentLength {
+		return shim.Error("The comment is longer than " + strconv.Itoa(MaxCommentLength) + " characters.")
+	}
+
+	// STEP 0: check if service exists
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if serviceJSON.Status == S_Invalid {
+		return shim.Error("This service is invalidated and can't be commented: " + service_name)
+	}
+
+	// STEP 1: store the comment
+	commenter, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	err = validateAddress(commenter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotBanned(stub, commenter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the developer would be paid for commenting on their own service
+	owner, err := isServiceOwner(stub, serviceJSON, commenter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner {
+		return shim.Error("The developer can't comment on the service.")
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tx_id := stub.GetTxID()
+	newC := &comment{Service: service_name, Commenter: commenter, Content: content,
+		TxId: tx_id, Time: formatTime(tNow)}
+	commentJSONasBytes, err := json.Marshal(newC)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(CommentPrefix+service_name+"_"+tx_id, commentJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: reward the developer; mashups record an address
+	// rather than a user, which givesToken can't resolve
+	if !serviceJSON.IsMashup {
+		response := t.givesToken(stub, []string{IncentiveBalanceType, serviceJSON.Developer, "6"})
+		if response.Status != shim.OK {
+			return shim.Error("Fail to reward the developer: " + response.Message)
+		}
+	}
+
+	return shim.Success([]byte("Comment the service success."))
+}
+
+// =======================================================
+// queryServiceComments: the comments on a service,
+// oldest first
+// =======================================================
+func (t *serviceChaincode) queryServiceComments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var service_name string
+	var err error
+
+	service_name, err = normalizeName(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	comments, err := getServiceComments(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if comments == nil {
+		comments = []comment{}
+	}
+	times := make(map[string]time.Time)
+	for _, commentJSON := range comments {
+		times[commentJSON.TxId], err = parseTime(commentJSON.Time)
+		if err != nil {
+			return shim.Error("Error time in comment: " + commentJSON.TxId)
+		}
+	}
+
+	// keys are ordered by txid, order the comments by time instead
+	sort.SliceStable(comments, func(i, j int) bool {
+		return times[comments[i].TxId].Before(times[comments[j].TxId])
+	})
+
+	commentsAsBytes, err := json.Marshal(comments)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(commentsAsBytes)
+}
+
+// =======================================================
+// givesToken: reward a service
+// reward a service's developer, transfer fixed amount of
+// specific reward_type token to the developer's account.
+// =======================================================
+func (t *serviceChaincode) givesToken(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 3); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var reward_type string
+	var userName string
+	var incentive_type string
+	var err error
+
+	reward_type = args[0]
+	userName, err = normalizeName(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	incentive_type = args[2]
+
+	amounts, err := getIncentiveAmounts(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, ok := amounts[incentive_type]
+	if !ok {
+		return shim.Error("Error incentive type, expecting 1 to 7: " + incentive_type)
+	}
+	// Amount
+	reward_amount := big.NewInt(0)
+	_, good := reward_amount.SetString(amount, 10)
+	if !good {
+		return shim.Error("Expecting integer value for amount")
+		// return "Error"
+	}
+
+	// STEP 1: get the address of the dev
+	user_key := UserPrefix + userName
+	userAsBytes, err := stub.GetState(user_key)
+	if err != nil {
+		return shim.Error("Fail to get the developer's info.")
+		// return "Error"
+	}
+	var userJSON user
+	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+		// return "Error"
+	}
+
+	// STEP 3: reward the developer, from another account: a transfer to
+	// oneself moves nothing and mustn't count as an earning
+	toAdd := userJSON.Address
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if sameAddress(senderAdd, toAdd) {
+		return shim.Error("A developer can't reward themselves.")
+	}
+	err = stub.Transfer(toAdd, reward_type, reward_amount)
+	if err != nil {
+		return shim.Error("Fail realize the reawrd.")
+		// return "Error"
+	}
+	err = recordEarning(stub, userName, reward_type, reward_amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Reward the service success."))
+	// return "Ok"
+}
+
+// Invoke func about admin
+// ==================================================================================
+
+// ===========================================
+// setConfig: update a configurable parameter
+// ===========================================
+func (t *serviceChaincode) setConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var config_name string
+	var config_value string
+	var err error
+
+	config_name = args[0]
+	config_value = args[1]
+
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	spec, ok := configSpecs[config_name]
+	if !ok {
+		return shim.Error("Unknown config name: " + config_name)
+	}
+	err = spec.Validate(config_value)
+	if err != nil {
+		return shim.Error("Invalid value for " + config_name + ": " + err.Error())
+	}
+	if config_name == ConfigTreasury {
+		config_value = normalizeAddress(config_value)
+	}
+
+	err = stub.PutState(ConfigPrefix+config_name, []byte(config_value))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Set config success."))
+}
+
+// ===================================================================
+// setMashupIncentive: update the INK paid to each composited
+// developer on mashup creation, a shorthand for MASHUP_INCENTIVE
+// ===================================================================
+func (t *serviceChaincode) setMashupIncentive(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+	return t.setConfig(stub, []string{ConfigIncentive, args[0]})
+}
+
+// ===================================================================
+// setIncentiveToken: update the token mashup incentives are paid in,
+// a shorthand for INCENTIVE_TOKEN that makes sure the token exists
+// ===================================================================
+func (t *serviceChaincode) setIncentiveToken(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+	err := checkToken(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return t.setConfig(stub, []string{ConfigIncToken, args[0]})
+}
+
+// ===================================================================
+// setProtocolFee: update the share of paid invocations sent to the
+// treasury, a shorthand for PROTOCOL_FEE_BPS that may also set the
+// TREASURY address
+// ===================================================================
+func (t *serviceChaincode) setProtocolFee(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) > 1 {
+		treasury := args[1]
+		err := validateAddress(treasury)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		response := t.setConfig(stub, []string{ConfigTreasury, treasury})
+		if response.Status != shim.OK {
+			return response
+		}
+	}
+	return t.setConfig(stub, []string{ConfigFeeBps, args[0]})
+}
+
+// ===================================================================
+// setIncentiveAmount: update the amount givesToken pays for an
+// incentive type, invoked by an admin
+// ===================================================================
+func (t *serviceChaincode) setIncentiveAmount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 2); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	incentive_type := args[0]
+	amount := args[1]
+
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, ok := defaultIncentiveAmounts[incentive_type]; !ok {
+		return shim.Error("Error incentive type, expecting 1 to 7: " + incentive_type)
+	}
+	err = validateNonNegativeBigInt(amount)
+	if err != nil {
+		return shim.Error("Invalid amount for incentive type " + incentive_type + ": " + err.Error())
+	}
+
+	amounts, err := getIncentiveAmounts(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amounts[incentive_type] = amount
+	amountsAsBytes, err := json.Marshal(amounts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(IncentiveAmountsKey, amountsAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Set incentive amount success."))
+}
+
+// ===================================================================
+// addAdmin: register a new admin, invoked by an admin
+// ===================================================================
+func (t *serviceChaincode) addAdmin(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var admin_add string
+	var err error
+
+	admin_add = args[0]
+	err = validateAddress(admin_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	isAdm, err := isAdmin(stub, admin_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if isAdm {
+		return shim.Error("This admin already exists: " + admin_add)
+	}
+	err = stub.PutState(AdminPrefix+admin_add, []byte(admin_add))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Add admin success."))
+}
+
+// ===================================================================
+// setUserBanned: ban or unban a user, invoked by an admin
+// ===================================================================
+func (t *serviceChaincode) setUserBanned(stub shim.ChaincodeStubInterface, name string, banned bool) pb.Response {
+	user_name, err := normalizeName(name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check if user exists
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error(ERR_USER_NOT_FOUND + ": This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+	if userJSON.Banned == banned {
+		return shim.Error("This user's banned status is already " + strconv.FormatBool(banned) + ": " + user_name)
+	}
+
+	userJSON.Banned = banned
+	userJSONasBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if banned {
+		return shim.Success([]byte("Ban user success."))
+	}
+	return shim.Success([]byte("Unban user success."))
+}
+
+// ===================================================================
+// removeAdmin: unregister an admin, invoked by an admin;
+// the last admin can't be removed
+// ===================================================================
+func (t *serviceChaincode) removeAdmin(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var admin_add string
+	var err error
+
+	admin_add = args[0]
+
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	isAdm, err := isAdmin(stub, admin_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdm {
+		return shim.Error("This admin does not exist: " + admin_add)
+	}
+
+	resultsIterator, err := stub.GetStateByRange(AdminPrefix, AdminPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+	admins := 0
+	for resultsIterator.HasNext() {
+		_, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		admins++
+	}
+	if admins <= 1 {
+		return shim.Error("The last admin can't be removed: " + admin_add)
+	}
+
+	err = stub.DelState(AdminPrefix + admin_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Remove admin success."))
+}
+
+// =================================================================
+// sweepStaleDrafts: delete services that stayed in S_Created longer
+// than the configured DRAFT_TTL (in days).
+// Drafts still referenced by a mashup's composition are kept.
+// =================================================================
+func (t *serviceChaincode) sweepStaleDrafts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ttl_days, err := getConfigInt(stub, ConfigDraftTTL)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deadline := tNow.Add(-time.Duration(ttl_days) * 24 * time.Hour)
+
+	services, err := getAllServices(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// services referenced by any mashup must not be removed
+	referenced := make(map[string]bool)
+	for _, s := range services {
+		if s.IsMashup {
+			for k := range s.Composition {
+				referenced[k] = true
+			}
+		}
+	}
+
+	var cleaned []service
+	var cleaned_names []string
+	skipped := 0
+	for _, s := range services {
+		if s.Status != S_Created {
+			continue
+		}
+		tCreated, err := parseTime(s.CreatedTime)
+		if err != nil || !tCreated.Before(deadline) {
+			continue
+		}
+		if referenced[s.Name] {
+			skipped++
+			continue
+		}
+		err = stub.DelState(ServicePrefix + s.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = unindexService(stub, s)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		cleaned = append(cleaned, s)
+		cleaned_names = append(cleaned_names, s.Name)
+	}
+	purged, err := purgeServiceData(stub, cleaned)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = markServicesChanged(stub, append(cleaned_names, purged...)...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]int{"cleaned": len(cleaned_names), "skipped": skipped})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// =================================================================
+// exportState: dump the users, services and tokens with their keys,
+// for a backup or a migration through importState.
+// The whole snapshot is built in memory in a single response, so at
+// most SCAN_LIMIT records are exported and MAX_RESPONSE_SIZE still
+// applies; a ledger over the limit fails rather than being cut short.
+// =================================================================
+func (t *serviceChaincode) exportState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scan_limit, err := getConfigInt(stub, ConfigScanLimit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// tokens are stored under their bare name, so the whole key space is walked
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	// only the exported records count toward the limit, not the indexes,
+	// votes, logs and other keys walked past
+	snapshot := stateSnapshot{Users: []snapshotEntry{}, Services: []snapshotEntry{}, Tokens: []snapshotEntry{}}
+	exported := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		entry := snapshotEntry{queryResponse.Key, json.RawMessage(queryResponse.Value)}
+		switch {
+		case strings.HasPrefix(queryResponse.Key, UserPrefix):
+			snapshot.Users = append(snapshot.Users, entry)
+		case strings.HasPrefix(queryResponse.Key, ServicePrefix):
+			snapshot.Services = append(snapshot.Services, entry)
+		case isTokenRecord(queryResponse.Key, queryResponse.Value):
+			snapshot.Tokens = append(snapshot.Tokens, entry)
+		default:
+			continue
+		}
+		exported++
+		if exported > scan_limit {
+			return shim.Error(fmt.Sprintf("%s: the ledger holds more than %d records, raise %s to export it.",
+				ERR_RESPONSE_TOO_LARGE, scan_limit, ConfigScanLimit))
+		}
+	}
+
+	snapshotAsBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(snapshotAsBytes)
+}
+
+// =================================================================
+// importState: store the records of an exportState snapshot whose
+// keys are absent, so that live data is never overwritten; the
+// address and creation time indexes of the records are rebuilt
+// =================================================================
+func (t *serviceChaincode) importState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var snapshot stateSnapshot
+	err = json.Unmarshal([]byte(args[0]), &snapshot)
+	if err != nil {
+		return shim.Error(ERR_BAD_ARGS + ": the snapshot is not valid JSON: " + err.Error())
+	}
+
+	// STEP 0: check every record before writing any
+	for _, entry := range snapshot.Users {
+		var userJSON user
+		if !strings.HasPrefix(entry.Key, UserPrefix) || json.Unmarshal(entry.Record, &userJSON) != nil ||
+			UserPrefix+userJSON.Name != entry.Key {
+			return shim.Error(ERR_BAD_ARGS + ": not a user record: " + entry.Key)
+		}
+	}
+	for _, entry := range snapshot.Services {
+		var serviceJSON service
+		if !strings.HasPrefix(entry.Key, ServicePrefix) || json.Unmarshal(entry.Record, &serviceJSON) != nil ||
+			ServicePrefix+serviceJSON.Name != entry.Key {
+			return shim.Error(ERR_BAD_ARGS + ": not a service record: " + entry.Key)
+		}
+	}
+	for _, entry := range snapshot.Tokens {
+		if !isTokenRecord(entry.Key, entry.Record) {
+			return shim.Error(ERR_BAD_ARGS + ": not a token record: " + entry.Key)
+		}
+	}
+
+	// STEP 1: write the absent records; writes aren't visible to GetState
+	// within the transaction, so keys repeated in the snapshot are tracked
+	written := make(map[string]bool)
+	imported := 0
+	skipped := 0
+	var service_names []string
+	sections := [][]snapshotEntry{snapshot.Users, snapshot.Services, snapshot.Tokens}
+	for section, entries := range sections {
+		for _, entry := range entries {
+			existingAsBytes, err := stub.GetState(entry.Key)
+			if err != nil {
+				return shim.Error("Fail to get " + entry.Key + ": " + err.Error())
+			}
+			if existingAsBytes != nil || written[entry.Key] {
+				skipped++
+				continue
+			}
+			err = stub.PutState(entry.Key, entry.Record)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			written[entry.Key] = true
+			imported++
+
+			switch section {
+			case 0:
+				var userJSON user
+				json.Unmarshal(entry.Record, &userJSON)
+				err = importAddressIndex(stub, userJSON, written)
+			case 1:
+				var serviceJSON service
+				json.Unmarshal(entry.Record, &serviceJSON)
+				err = indexService(stub, serviceJSON)
+				service_names = append(service_names, serviceJSON.Name)
+			}
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+	err = markServicesChanged(stub, service_names...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]int{"imported": imported, "skipped": skipped})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ==================================================
+// invalidateToken: mark a token as invalidated so it
+// can never be issued through initAccount again
+// ==================================================
+func (t *serviceChaincode) invalidateToken(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 1); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var tokenName string
+	var err error
+
+	tokenName = args[0]
+
+	_, err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// check if token exists
+	existTokenBytes, err := stub.GetState(tokenName)
+	if err != nil {
+		return shim.Error("Check token existance error, fail to getState of " + tokenName)
+	} else if existTokenBytes == nil {
+		return shim.Error("This token does not exist: " + tokenName)
+	}
+	var existToken Token
+	err = json.Unmarshal(existTokenBytes, &existToken)
+	if err != nil {
+		return shim.Error("Unmarshal exist tokenBytes err " + tokenName)
+	}
+	if existToken.Status == Invalidate {
+		return shim.Error("This token is already invalidated: " + tokenName)
+	}
+
+	existToken.Status = Invalidate
+	existTokenJson, err := json.Marshal(&existToken)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(tokenName, existTokenJson)
+	if err != nil {
+		return shim.Error("Store the latest token status err.")
+	}
+
+	return shim.Success([]byte("Invalidate token success."))
+}
+
+// ===================================================================
+// findDuplicateDescriptions: group the services sharing an identical
+// description (compared case- and whitespace-insensitively).
+// At most SCAN_LIMIT services are scanned and MaxDuplicateGroups
+// groups are returned, largest first.
+// ===================================================================
+func (t *serviceChaincode) findDuplicateDescriptions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scan_limit, err := getConfigInt(stub, ConfigScanLimit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(rangeError(err).Error())
+	}
+	defer resultsIterator.Close()
+
+	groups := make(map[string][]string)
+	scanned := 0
+	truncated := false
+	for resultsIterator.HasNext() {
+		if scanned >= scan_limit {
+			truncated = true
+			break
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		scanned++
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes.")
+		}
+		normalized := strings.ToLower(strings.Join(strings.Fields(serviceJSON.Description), " "))
+		if normalized == "" {
+			continue
+		}
+		hash := sha256.Sum256([]byte(normalized))
+		hash_str := hex.EncodeToString(hash[:])
+		groups[hash_str] = append(groups[hash_str], serviceJSON.Name)
+	}
+
+	type duplicateGroup struct {
+		Hash     string   `json:"hash"`
+		Services []string `json:"services"`
+	}
+	duplicates := []duplicateGroup{}
+	for hash_str, names := range groups {
+		if len(names) > 1 {
+			duplicates = append(duplicates, duplicateGroup{hash_str, names})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if len(duplicates[i].Services) != len(duplicates[j].Services) {
+			return len(duplicates[i].Services) > len(duplicates[j].Services)
+		}
+		return duplicates[i].Hash < duplicates[j].Hash
+	})
+	if len(duplicates) > MaxDuplicateGroups {
+		duplicates = duplicates[:MaxDuplicateGroups]
+		truncated = true
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]interface{}{
+		"scanned":    scanned,
+		"truncated":  truncated,
+		"duplicates": duplicates,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===================================================================
+// decayContributions: reduce the contribution of inactive users by
+// DECAY_PERCENT for every DECAY_PERIOD days elapsed since LastActive.
+// Periods already applied are remembered, so sweeping again is safe.
+// ===================================================================
+func (t *serviceChaincode) decayContributions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	_, err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	decay_pct, err := getConfigInt(stub, ConfigDecayPct)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	decay_days, err := getConfigInt(stub, ConfigDecayDays)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if decay_pct == 0 {
+		return shim.Error("The contribution decay is disabled.")
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	period := time.Duration(decay_days) * 24 * time.Hour
+
+	users, err := getAllUsers(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	decayed := 0
+	for _, userJSON := range users {
+		tActive, err := parseTime(userJSON.LastActive)
+		if err != nil {
+			// users registered before LastActive was recorded
+			continue
+		}
+		periods := int(tNow.Sub(tActive) / period)
+		if periods <= userJSON.DecayedPeriods {
+			continue
+		}
+		contribution := decayBy(userJSON.Contribution, periods-userJSON.DecayedPeriods, decay_pct)
+		userJSON.DecayedPeriods = periods
+		if contribution != userJSON.Contribution {
+			userJSON.Contribution = contribution
+			decayed++
+		}
+		userJSONasBytes, err := json.Marshal(userJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(UserPrefix+userJSON.Name, userJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]int{"decayed": decayed})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===================================================================
+// grantFromTreasury: pay a grant out of the treasury and record it.
+// Only the treasury address itself can move its funds.
+// ===================================================================
+func (t *serviceChaincode) grantFromTreasury(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if err := checkArgs(args, 4); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var to_add string
+	var token_type string
+	var reason string
+	var err error
+
+	to_add = args[0]
+	token_type = args[1]
+	reason = args[3]
+
+	amount := big.NewInt(0)
+	_, good := amount.SetString(args[2], 10)
+	if !good || amount.Sign() <= 0 {
+		return shim.Error("Expecting positive integer value for amount")
+	}
+
+	treasury, err := getConfig(stub, ConfigTreasury)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if treasury == "" || !sameAddress(senderAdd, treasury) {
+		return shim.Error("Authority err! Not invoke by the treasury.")
+	}
+
+	err = stub.Transfer(to_add, token_type, amount)
+	if err != nil {
+		return shim.Error("Error when making transfer: " + err.Error())
+	}
+	err = recordTreasuryEntry(stub, TreasuryOutflow, treasury, to_add, amount, token_type, reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Grant from treasury success."))
+}
+
+// ===================================================================
+// queryTreasuryBalance: the treasury's net inflow per token type,
+// summed from the treasury ledger
+// ===================================================================
+func (t *serviceChaincode) queryTreasuryBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	entries, err := getTreasuryEntries(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	totals := make(map[string]*big.Int)
+	for _, entry := range entries {
+		amount, good := big.NewInt(0).SetString(entry.Amount, 10)
+		if !good {
+			entry_id := entry.TxID
+			if entry_id == "" {
+				entry_id = strconv.Itoa(entry.Seq)
+			}
+			return shim.Error("Error amount in treasury entry " + entry_id)
+		}
+		if totals[entry.Token] == nil {
+			totals[entry.Token] = big.NewInt(0)
+		}
+		if entry.Direction == TreasuryOutflow {
+			totals[entry.Token].Sub(totals[entry.Token], amount)
+		} else {
+			totals[entry.Token].Add(totals[entry.Token], amount)
+		}
+	}
+
+	balances := make(map[string]string)
+	for token_type, total := range totals {
+		balances[token_type] = total.String()
+	}
+	balancesAsBytes, err := json.Marshal(balances)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(balancesAsBytes)
+}
+
+// ===================================================================
+// queryTreasuryLog: every treasury ledger entry, oldest first
+// ===================================================================
+func (t *serviceChaincode) queryTreasuryLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	entries, err := getTreasuryEntries(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if entries == nil {
+		entries = []treasuryEntry{}
+	}
+	entriesAsBytes, err := json.Marshal(entries)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(entriesAsBytes)
+}
+
+// Helper func
+// ==================================================================================
+
+// checkArgs makes sure a handler got the arguments it indexes, whatever
+// the dispatcher let through, so a malformed call can't panic
+func checkArgs(args []string, n int) error {
+	if len(args) < n {
+		return fmt.Errorf("%s: expecting %d arguments at least, got %d.", ERR_BAD_ARGS, n, len(args))
+	}
+	return nil
+}
+
+// configSpec describes a configurable parameter: its default value
+// and how a new value is validated before being stored.
+type configSpec struct {
+	Default  string
+	Validate func(value string) error
+}
+
+var configSpecs = map[string]configSpec{
+	ConfigDraftTTL:   {"30", validateNonNegativeInt},
+	ConfigMashupFee:  {"0", validateNonNegativeBigInt},
+	ConfigTreasury:   {"", validateAddress},
+	ConfigRecentSize: {"100", validateNonNegativeInt},
+	ConfigScanLimit:  {"10000", validateNonNegativeInt},
+	ConfigDecayPct:   {"0", validatePercent},
+	ConfigDecayDays:  {"30", validatePositiveInt},
+	ConfigQuota:      {"0", validateNonNegativeInt},
+	ConfigExternal:   {"false", validateBool},
+	ConfigMaxPayload: {"0", validateNonNegativeInt},
+	ConfigIncentive:  {IncentiveMashupInvoke, validateNonNegativeBigInt},
+	ConfigWPublished: {"10", validateNonNegativeInt},
+	ConfigWComposed:  {"5", validateNonNegativeInt},
+	ConfigWReview:    {"1", validateNonNegativeInt},
+	ConfigMaxName:    {"64", validatePositiveInt},
+	ConfigMaxDesc:    {"2048", validatePositiveInt},
+	ConfigMaxIntro:   {"2048", validatePositiveInt},
+	ConfigIncToken:   {IncentiveBalanceType, validateNonEmpty},
+	ConfigDeprecated: {"false", validateBool},
+	ConfigFeeBps:     {"0", validateBasisPoints},
+	ConfigMaxComp:    {"50", validatePositiveInt},
+	ConfigMaxBatch:   {"50", validatePositiveInt},
+	ConfigDataPrice:  {"10", validateNonNegativeBigInt},
+	ConfigTRating:    {"10", validateNonNegativeInt},
+	ConfigTInvoke:    {"1", validateNonNegativeInt},
+	ConfigTContrib:   {"1", validateNonNegativeInt},
+	ConfigTAge:       {"1", validateNonNegativeInt},
+}
+
+func validateNonNegativeInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expecting an integer")
+	}
+	if n < 0 {
+		return fmt.Errorf("expecting a non-negative integer")
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expecting an integer")
+	}
+	if n <= 0 {
+		return fmt.Errorf("expecting a positive integer")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("expecting true or false")
+	}
+	return nil
+}
+
+func validatePercent(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expecting an integer")
+	}
+	if n < 0 || n > 100 {
+		return fmt.Errorf("expecting a percentage between 0 and 100")
+	}
+	return nil
+}
+
+func validateBasisPoints(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expecting an integer")
+	}
+	if n < 0 || n > BasisPoints {
+		return fmt.Errorf("expecting basis points between 0 and %d", BasisPoints)
+	}
+	return nil
+}
+
+func validateNonNegativeBigInt(value string) error {
+	n, good := big.NewInt(0).SetString(value, 10)
+	if !good {
+		return fmt.Errorf("expecting an integer")
+	}
+	if n.Sign() < 0 {
+		return fmt.Errorf("expecting a non-negative integer")
+	}
+	return nil
+}
+
+// normalizeName gives the form user and service names are keyed on:
+// trimmed and lowercased, so that "MyService" and "myservice " are the
+// same service. Registrations keep the original as DisplayName.
+func normalizeName(name string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if normalized == "" {
+		return "", fmt.Errorf("The name can't be empty.")
+	}
+	if strings.IndexFunc(normalized, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("The name can't contain control characters: %q", name)
+	}
+	return normalized, nil
+}
+
+// checkLength makes sure a value is no longer, in characters, than the
+// limit set by the config_name parameter
+func checkLength(stub shim.ChaincodeStubInterface, config_name string, field string, value string) error {
+	limit, err := getConfigInt(stub, config_name)
+	if err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(value) > limit {
+		return fmt.Errorf("The %s is longer than %d characters.", field, limit)
+	}
+	return nil
+}
+
+// validateAddress checks an address is AddressLength hex digits,
+// optionally prefixed with "i" as INKchain wallets display them
+func validateAddress(address string) error {
+	digits := normalizeAddress(address)
+	if len(digits) != AddressLength {
+		return fmt.Errorf("Malformed address, expecting %d hex digits: %s", AddressLength, address)
+	}
+	_, err := hex.DecodeString(digits)
+	if err != nil {
+		return fmt.Errorf("Malformed address, expecting %d hex digits: %s", AddressLength, address)
+	}
+	return nil
+}
+
+// normalizeAddress: the canonical form of an address, without the "i"
+// prefix, under which addresses are indexed and compared
+func normalizeAddress(address string) string {
+	return strings.TrimPrefix(address, "i")
+}
+
+// sameAddress: whether two addresses are the same once normalized
+func sameAddress(a string, b string) bool {
+	return normalizeAddress(a) == normalizeAddress(b)
+}
+
+// addressKey: the ADDR_ index key of an address
+func addressKey(address string) string {
+	return AddressPrefix + normalizeAddress(address)
+}
+
+func validateNonEmpty(value string) error {
+	if value == "" {
+		return fmt.Errorf("expecting a non-empty value")
+	}
+	return nil
+}
+
+// ===================================================================
+// checkResponseSize: replace a successful response whose payload is
+// over MAX_RESPONSE_SIZE by an ERR_RESPONSE_TOO_LARGE error, rather than
+// letting the peer reject it opaquely
+// ===================================================================
+func checkResponseSize(stub shim.ChaincodeStubInterface, response pb.Response) pb.Response {
+	if response.Status != shim.OK {
+		return response
+	}
+	limit, err := getConfigInt(stub, ConfigMaxPayload)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if limit > 0 && len(response.Payload) > limit {
+		return shim.Error(fmt.Sprintf("%s: the response takes %d bytes, over the limit of %d bytes; "+
+			"narrow the query's filters or page through the results.", ERR_RESPONSE_TOO_LARGE, len(response.Payload), limit))
+	}
+	return response
+}
+
+// ===================================================================
+// getConfig: read a configurable parameter, falling back to default
+// ===================================================================
+func getConfig(stub shim.ChaincodeStubInterface, config_name string) (string, error) {
+	configAsBytes, err := stub.GetState(ConfigPrefix + config_name)
+	if err != nil {
+		return "", fmt.Errorf("Fail to get config %s: %s", config_name, err.Error())
+	}
+	if configAsBytes == nil {
+		return configSpecs[config_name].Default, nil
+	}
+	return string(configAsBytes), nil
+}
+
+func getConfigInt(stub shim.ChaincodeStubInterface, config_name string) (int, error) {
+	value, err := getConfig(stub, config_name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Config %s is not an integer: %s", config_name, value)
+	}
+	return n, nil
+}
+
+// ===================================================================
+// requireAdmin: make sure the invocation comes from a registered admin
+// ===================================================================
+func requireAdmin(stub shim.ChaincodeStubInterface) (string, error) {
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return "", fmt.Errorf("Fail to get the sender's address.")
+	}
+	isAdm, err := isAdmin(stub, senderAdd)
+	if err != nil {
+		return "", err
+	}
+	if !isAdm {
+		return "", fmt.Errorf("Authority err! Not invoke by an admin.")
+	}
+	return senderAdd, nil
+}
+
+// isAdmin checks whether the address is in the admin registry
+func isAdmin(stub shim.ChaincodeStubInterface, address string) (bool, error) {
+	adminAsBytes, err := stub.GetState(AdminPrefix + address)
+	if err != nil {
+		return false, fmt.Errorf("Fail to get admin: %s", err.Error())
+	}
+	return adminAsBytes != nil, nil
+}
+
+// ===================================================================
+// isServiceOwner: check whether the address belongs to the service's
+// developer. Services record the developer's user name; mashups record
+// the creator's address directly, which is compared as is.
+// ===================================================================
+func isServiceOwner(stub shim.ChaincodeStubInterface, serviceJSON service, address string) (bool, error) {
+	if serviceJSON.IsMashup {
+		return sameAddress(serviceJSON.Developer, address), nil
+	}
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil {
+		return false, err
+	}
+	return DevJSON != nil && sameAddress(DevJSON.Address, address), nil
+}
+
+// ===================================================================
+// requireDeveloper: load a service, making sure the sender is its
+// developer
+// ===================================================================
+func requireDeveloper(stub shim.ChaincodeStubInterface, service_name string) (service, error) {
+	serviceJSON, senderAdd, err := loadServiceAndSender(stub, service_name)
+	if err != nil {
+		return service{}, err
+	}
+	owner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return service{}, err
+	}
+	if !owner {
+		return service{}, fmt.Errorf("Aurthority err! Not invoke by the service's developer.")
+	}
+	return serviceJSON, nil
+}
+
+// requireDeveloperOrAdmin: same as requireDeveloper, an admin is let
+// through too; tells whether it is the developer
+func requireDeveloperOrAdmin(stub shim.ChaincodeStubInterface, service_name string) (service, bool, error) {
+	serviceJSON, senderAdd, err := loadServiceAndSender(stub, service_name)
+	if err != nil {
+		return service{}, false, err
+	}
+	by_developer, err := authorizeDeveloperOrAdmin(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return service{}, false, err
+	}
+	return serviceJSON, by_developer, nil
+}
+
+// loadServiceAndSender: a service and the sender's address
+func loadServiceAndSender(stub shim.ChaincodeStubInterface, service_name string) (service, string, error) {
+	var serviceJSON service
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return serviceJSON, "", fmt.Errorf("Fail to get service: %s", err.Error())
+	} else if serviceAsBytes == nil {
+		return serviceJSON, "", fmt.Errorf("This service does not exist: %s", service_name)
+	}
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return serviceJSON, "", fmt.Errorf("Error unmarshal service bytes.")
+	}
+
+	senderAdd, err := stub.GetSender()
+	if err != nil {
+		return serviceJSON, "", fmt.Errorf("Fail to get the sender's address.")
+	}
+	return serviceJSON, senderAdd, nil
+}
+
+// getDeveloper: the developer of a service, nil if no longer registered;
+// a mashup's creator is the user its address is indexed to, if any
+func getDeveloper(stub shim.ChaincodeStubInterface, serviceJSON service) (*user, error) {
+	if serviceJSON.IsMashup {
+		return getIndexedUser(stub, serviceJSON.Developer)
+	}
+	devAsBytes, err := stub.GetState(UserPrefix + serviceJSON.Developer)
+	if err != nil {
+		return nil, fmt.Errorf("Error get the developer.")
+	}
+	if devAsBytes == nil {
+		return nil, nil
+	}
+	var DevJSON user
+	err = json.Unmarshal(devAsBytes, &DevJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshal user bytes.")
+	}
+	return &DevJSON, nil
+}
+
+// trustWeights holds the TRUST_* configs trustScore weighs its signals with
+type trustWeights struct {
+	Rating, Invoke, Contrib, Age int
+}
+
+// getTrustWeights reads the TRUST_* configs
+func getTrustWeights(stub shim.ChaincodeStubInterface) (trustWeights, error) {
+	var weights trustWeights
+	for _, weight := range []struct {
+		config_name string
+		value       *int
+	}{
+		{ConfigTRating, &weights.Rating},
+		{ConfigTInvoke, &weights.Invoke},
+		{ConfigTContrib, &weights.Contrib},
+		{ConfigTAge, &weights.Age},
+	} {
+		value, err := getConfigInt(stub, weight.config_name)
+		if err != nil {
+			return trustWeights{}, err
+		}
+		*weight.value = value
+	}
+	return weights, nil
+}
+
+// ===================================================================
+// trustScore: rate a service for relevance ranking, as the sum of
+//   - TRUST_RATING per net upvote (Up - Down), negative when the
+//     downvotes win
+//   - TRUST_INVOKE per invocation
+//   - TRUST_CONTRIB per point of the developer's contribution, none
+//     when the developer is gone
+//   - TRUST_AGE per whole day since the service was created, as of tNow
+//
+// ===================================================================
+func trustScore(stub shim.ChaincodeStubInterface, serviceJSON service, weights trustWeights, tNow time.Time) (int, error) {
+	score := weights.Rating * (serviceJSON.Up - serviceJSON.Down)
+
+	invocations, err := countInvocations(stub, serviceJSON.Name)
+	if err != nil {
+		return 0, err
+	}
+	score += weights.Invoke * (serviceJSON.InvokeCount + invocations)
+
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil {
+		return 0, err
+	}
+	if DevJSON != nil {
+		score += weights.Contrib * DevJSON.Contribution
+	}
+
+	if created, err := parseTime(serviceJSON.CreatedTime); err == nil && tNow.After(created) {
+		score += weights.Age * int(tNow.Sub(created)/(24*time.Hour))
+	}
+	return score, nil
+}
+
+// touchDeveloper: mark the developer of a service active
+func touchDeveloper(stub shim.ChaincodeStubInterface, serviceJSON service) error {
+	DevJSON, err := getDeveloper(stub, serviceJSON)
+	if err != nil || DevJSON == nil {
+		return err
+	}
+	return touchUser(stub, *DevJSON)
+}
+
+// ===================================================================
+// authorizeDeveloperOrAdmin: let the service's developer or an admin
+// through, and tell whether it is the developer
+// ===================================================================
+func authorizeDeveloperOrAdmin(stub shim.ChaincodeStubInterface, serviceJSON service, senderAdd string) (bool, error) {
+	owner, err := isServiceOwner(stub, serviceJSON, senderAdd)
+	if err != nil {
+		return false, err
+	}
+	if owner {
+		fmt.Println("Authorized as developer:  " + senderAdd)
+		return true, nil
+	}
+	isAdm, err := isAdmin(stub, senderAdd)
+	if err != nil {
+		return false, err
+	}
+	if !isAdm {
+		return false, fmt.Errorf("Aurthority err! Not invoke by the service's developer or an admin.")
+	}
+	fmt.Println("Authorized as admin:  " + senderAdd)
+	return false, nil
+}
+
+// ===================================================================
+// getBalance: the address's balance of a token type, zero if the
+// account does not hold that token
+// ===================================================================
+func getBalance(stub shim.ChaincodeStubInterface, address string, balanceType string) (*big.Int, error) {
+	account, err := stub.GetAccount(address)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get the account of %s.", address)
+	}
+	if account == nil || account.Balance[balanceType] == nil {
+		return big.NewInt(0), nil
+	}
+	return account.Balance[balanceType], nil
+}
+
+// ===================================================================
+// getChangedSince: names of the services changed since a time, in
+// the order of their first change, at most RECENT_SIZE of them;
+// changes older than the index come from the RECENT_CHANGED list
+// ===================================================================
+func getChangedSince(stub shim.ChaincodeStubInterface, since time.Time) ([]string, error) {
+	size, err := getConfigInt(stub, ConfigRecentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// STEP 1: the index sorts by time, range over the changes after the snapshot
+	start_key, err := stub.CreateCompositeKey(ChangedIndex, []string{indexTime(since.Add(time.Nanosecond))})
+	if err != nil {
+		return nil, err
+	}
+	index_prefix, err := stub.CreateCompositeKey(ChangedIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := stub.GetStateByRange(start_key, index_prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var changed []string
+	seen := make(map[string]bool)
+	for resultsIterator.HasNext() && len(changed) < size {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) != 3 {
+			continue
+		}
+		if !seen[attributes[2]] {
+			seen[attributes[2]] = true
+			changed = append(changed, attributes[2])
+		}
+	}
+
+	// STEP 2: the older changes come first, as room is left
+	recentAsBytes, err := stub.GetState(RecentChangedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get the recently changed services: %s", err.Error())
+	}
+	var names []string
+	if recentAsBytes != nil {
+		var recent []string
+		err = json.Unmarshal(recentAsBytes, &recent)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal the recently changed services.")
+		}
+		for _, name := range recent {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	names = append(names, changed...)
+	if len(names) > size {
+		names = names[len(names)-size:]
+	}
+	return names, nil
+}
+
+// ===================================================================
+// markServicesChanged: record the services as changed by the
+// transaction, each under its own key of the changed~time~txid~name
+// index so that concurrent transactions never write the same key
+// ===================================================================
+func markServicesChanged(stub shim.ChaincodeStubInterface, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	tx_id := stub.GetTxID()
+	for _, name := range names {
+		index_key, err := stub.CreateCompositeKey(ChangedIndex, []string{indexTime(tNow), tx_id, name})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(index_key, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexTime formats a time as an index attribute, zero-padded
+// nanoseconds so that the attributes sort in time order
+func indexTime(t time.Time) string {
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
+
+// ===================================================================
+// getAllUsers: load every user record stored under UserPrefix
+// ===================================================================
+func getAllUsers(stub shim.ChaincodeStubInterface) ([]user, error) {
+	resultsIterator, err := stub.GetStateByRange(UserPrefix, UserPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var users []user
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var userJSON user
+		err = json.Unmarshal(queryResponse.Value, &userJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal user bytes: %s", queryResponse.Key)
+		}
+		users = append(users, userJSON)
+	}
+	return users, nil
+}
+
+// ===================================================================
+// getIncentiveAmounts: the amounts givesToken pays by incentive type;
+// types missing from the stored map keep their default
+// ===================================================================
+func getIncentiveAmounts(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	amounts := make(map[string]string)
+	for incentive_type, amount := range defaultIncentiveAmounts {
+		amounts[incentive_type] = amount
+	}
+	amountsAsBytes, err := stub.GetState(IncentiveAmountsKey)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get the incentive amounts: %s", err.Error())
+	}
+	if amountsAsBytes != nil {
+		err = json.Unmarshal(amountsAsBytes, &amounts)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal the incentive amounts.")
+		}
+	}
+	return amounts, nil
+}
+
+// ===================================================================
+// getIncentiveToken: read the token mashup incentives are paid in,
+// making sure it can still be transferred
+// ===================================================================
+func getIncentiveToken(stub shim.ChaincodeStubInterface) (string, error) {
+	token_name, err := getConfig(stub, ConfigIncToken)
+	if err != nil {
+		return "", err
+	}
+	err = checkToken(stub, token_name)
+	if err != nil {
+		return "", err
+	}
+	return token_name, nil
+}
+
+// checkToken: INK is native, any other token needs an issued
+// Token record
+func checkToken(stub shim.ChaincodeStubInterface, token_name string) error {
+	if token_name == IncentiveBalanceType {
+		return nil
+	}
+	tokenAsBytes, err := stub.GetState(token_name)
+	if err != nil {
+		return fmt.Errorf("Fail to get token %s: %s", token_name, err.Error())
+	}
+	if tokenAsBytes == nil {
+		return fmt.Errorf("The token has not been issued: %s", token_name)
+	}
+	var tokenJSON Token
+	err = json.Unmarshal(tokenAsBytes, &tokenJSON)
+	if err != nil {
+		return fmt.Errorf("Error unmarshal token bytes: %s", token_name)
+	}
+	if tokenJSON.Status != Delivered {
+		return fmt.Errorf("The token is %s, not %s: %s", tokenJSON.Status, Delivered, token_name)
+	}
+	return nil
+}
+
+// ===================================================================
+// scaleAmount: convert a human-readable amount such as "1.5" to the
+// token's smallest unit, given its decimals
+// ===================================================================
+func scaleAmount(amount string, decimals int) (*big.Int, error) {
+	whole, fraction := amount, ""
+	if dot := strings.Index(amount, "."); dot >= 0 {
+		whole, fraction = amount[:dot], amount[dot+1:]
+	}
+	if len(fraction) > decimals {
+		return nil, fmt.Errorf("Expecting at most %d decimals for amount: %s", decimals, amount)
+	}
+	digits := whole + fraction + strings.Repeat("0", decimals-len(fraction))
+	scaled, good := big.NewInt(0).SetString(digits, 10)
+	if !good || strings.ContainsAny(digits, "+-") {
+		return nil, fmt.Errorf("Expecting a non-negative number for amount: %s", amount)
+	}
+	return scaled, nil
+}
+
+// ===================================================================
+// planMashup: resolve a mashup's composition and its incentives,
+// checking every component as createMashup requires; shared by
+// createMashup and simulateMashup so that both stay in sync
+// ===================================================================
+func planMashup(stub shim.ChaincodeStubInterface, mashup_name string, mashup_dev string, components []string) (*mashupPlan, error) {
+	// the payouts and composition walks grow with the composition
+	max_composition, err := getConfigInt(stub, ConfigMaxComp)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) > max_composition {
+		return nil, fmt.Errorf("A mashup composites %d services at most, got %d.", max_composition, len(components))
+	}
+
+	// only available services can be composited, unless an admin creates the mashup
+	by_admin, err := isAdmin(stub, mashup_dev)
+	if err != nil {
+		return nil, err
+	}
+
+	// create composition
+	plan := &mashupPlan{Composition: make(map[string]int), ComposedVersions: make(map[string]int),
+		Developers: make(map[string]int)}
+	external := false
+	checked := make(map[string]int)
+	for _, component := range components {
+		component_name, err := normalizeName(component)
+		if err != nil {
+			return nil, err
+		}
+		if plan.Composition[component_name] != 0 {
+			return nil, fmt.Errorf("This service is listed twice in the composition: %s", component_name)
+		}
+		// check the service exist
+		serviceAsBytes, err := stub.GetState(ServicePrefix + component_name)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to get service: %s", err.Error())
+		} else if serviceAsBytes == nil {
+			return nil, fmt.Errorf("This service doesn't exist: %s", component_name)
+		}
+		// add the service into map
+		plan.Composition[component_name] = 1
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal service bytes.")
+		}
+		if serviceJSON.Status == S_Deprecated && !by_admin {
+			allow_deprecated, err := getConfig(stub, ConfigDeprecated)
+			if err != nil {
+				return nil, err
+			}
+			if allow_deprecated != "true" {
+				return nil, fmt.Errorf("This service is deprecated: %s", component_name)
+			}
+		} else if serviceJSON.Status != S_Available && !by_admin {
+			return nil, fmt.Errorf("This service is not available: %s", component_name)
+		}
+		plan.ComposedVersions[component_name] = serviceJSON.Version
+		// the mashup must not end up in its own composition
+		err = checkCompositionCycle(stub, mashup_name, serviceJSON, 1, checked)
+		if err != nil {
+			return nil, err
+		}
+		// the mashup's developer isn't paid for compositing their own services
+		owner, err := isServiceOwner(stub, serviceJSON, mashup_dev)
+		if err != nil {
+			return nil, err
+		}
+		if !owner {
+			external = true
+			// the incentive goes to the registered user behind the component,
+			// a mashup whose creator never registered earns nothing
+			DevJSON, err := getDeveloper(stub, serviceJSON)
+			if err != nil {
+				return nil, err
+			}
+			if DevJSON != nil {
+				plan.Developers[DevJSON.Name] = 1
+			}
+		}
+	}
+
+	// optionally refuse mashups repackaging only the creator's own services
+	require_external, err := getConfig(stub, ConfigExternal)
+	if err != nil {
+		return nil, err
+	}
+	if require_external == "true" && !external {
+		return nil, fmt.Errorf("The mashup must composite at least one service of another developer.")
+	}
+
+	// incentives paid to each composited developer
+	incentive_str, err := getConfig(stub, ConfigIncentive)
+	if err != nil {
+		return nil, err
+	}
+	plan.Incentive = big.NewInt(0)
+	plan.Incentive.SetString(incentive_str, 10)
+	plan.IncentiveToken, err = getIncentiveToken(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mashup developer pays the creation fee to the treasury on top of the incentives
+	fee_str, err := getConfig(stub, ConfigMashupFee)
+	if err != nil {
+		return nil, err
+	}
+	plan.Fee = big.NewInt(0)
+	plan.Fee.SetString(fee_str, 10)
+
+	// the fee is always paid in INK, the incentives may be paid in another token
+	plan.Totals = map[string]*big.Int{IncentiveBalanceType: big.NewInt(0), plan.IncentiveToken: big.NewInt(0)}
+	plan.Totals[plan.IncentiveToken].Mul(big.NewInt(int64(len(plan.Developers))), plan.Incentive)
+	plan.Totals[IncentiveBalanceType].Add(plan.Totals[IncentiveBalanceType], plan.Fee)
+	return plan, nil
+}
+
+// migrateServicePrice: read records stored before the price existed as
+// free, priced in INK
+func migrateServicePrice(serviceJSON *service) {
+	if serviceJSON.Price == "" {
+		serviceJSON.Price = "0"
+	}
+	if serviceJSON.PriceToken == "" {
+		serviceJSON.PriceToken = IncentiveBalanceType
+	}
+}
+
+// ===================================================================
+// indexService / unindexService: maintain the index entries of a
+// service, in service~invtime and developer~service, and for a mashup
+// those of its components in component~mashup
+// ===================================================================
+func indexService(stub shim.ChaincodeStubInterface, serviceJSON service) error {
+	err := indexServiceTime(stub, serviceJSON)
+	if err != nil {
+		return err
+	}
+	developer_key, err := developerKey(stub, serviceJSON)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(developer_key, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	if !serviceJSON.IsMashup {
+		return nil
+	}
+	for component := range serviceJSON.Composition {
+		component_key, err := stub.CreateCompositeKey(ComponentIndex, []string{component, serviceJSON.Name})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(component_key, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexService(stub shim.ChaincodeStubInterface, serviceJSON service) error {
+	err := unindexServiceTime(stub, serviceJSON)
+	if err != nil {
+		return err
+	}
+	developer_key, err := developerKey(stub, serviceJSON)
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(developer_key)
+	if err != nil {
+		return err
+	}
+	if !serviceJSON.IsMashup {
+		return nil
+	}
+	for component := range serviceJSON.Composition {
+		component_key, err := stub.CreateCompositeKey(ComponentIndex, []string{component, serviceJSON.Name})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(component_key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// developerKey is the developer~service index key of a service
+func developerKey(stub shim.ChaincodeStubInterface, serviceJSON service) (string, error) {
+	developer := serviceJSON.Developer
+	if serviceJSON.IsMashup {
+		developer = normalizeAddress(developer)
+	}
+	return stub.CreateCompositeKey(DeveloperIndex, []string{developer, serviceJSON.Name})
+}
+
+// ===================================================================
+// getDeveloperServices: load the services the developer~service index
+// lists under a user, by name and by address, or under an address
+// without a user; services registered before the index aren't listed
+// ===================================================================
+func getDeveloperServices(stub shim.ChaincodeStubInterface, address string, userJSON *user) ([]service, error) {
+	developers := []string{normalizeAddress(address)}
+	if userJSON != nil {
+		developers = append(developers, userJSON.Name)
+	}
+
+	services := []service{}
+	for _, developer := range developers {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(DeveloperIndex, []string{developer})
+		if err != nil {
+			return nil, rangeError(err)
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil || len(attributes) != 2 {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("Malformed index key: %s", queryResponse.Key)
+			}
+			serviceAsBytes, err := stub.GetState(ServicePrefix + attributes[1])
+			if err != nil {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("Fail to get service: %s", err.Error())
+			}
+			if serviceAsBytes == nil {
+				continue
+			}
+			var serviceJSON service
+			err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("Error unmarshal service bytes: %s", attributes[1])
+			}
+			services = append(services, serviceJSON)
+		}
+		resultsIterator.Close()
+	}
+	return services, nil
+}
+
+// ===================================================================
+// indexServiceTime / unindexServiceTime: maintain the service~invtime
+// index entry of a service, keyed on its CreatedTime inverted so that
+// the newest services come first; a service whose created time can't
+// be parsed isn't indexed
+// ===================================================================
+func indexServiceTime(stub shim.ChaincodeStubInterface, serviceJSON service) error {
+	tCreated, err := parseTime(serviceJSON.CreatedTime)
+	if err != nil {
+		return nil
+	}
+	index_key, err := stub.CreateCompositeKey(ServiceTimeIndex, []string{invertedTime(tCreated), serviceJSON.Name})
+	if err != nil {
+		return err
+	}
+	// the index entry carries no value, fabric refuses an empty one
+	return stub.PutState(index_key, []byte{0x00})
+}
+
+func unindexServiceTime(stub shim.ChaincodeStubInterface, serviceJSON service) error {
+	// drop the entry of the former index along
+	legacy_key, err := stub.CreateCompositeKey(LegacyServiceTimeIndex, []string{serviceJSON.CreatedTime, serviceJSON.Name})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(legacy_key)
+	if err != nil {
+		return err
+	}
+	tCreated, err := parseTime(serviceJSON.CreatedTime)
+	if err != nil {
+		return nil
+	}
+	index_key, err := stub.CreateCompositeKey(ServiceTimeIndex, []string{invertedTime(tCreated), serviceJSON.Name})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(index_key)
+}
+
+// invertedTime formats a time as an index attribute that sorts the
+// newest times first
+func invertedTime(t time.Time) string {
+	return fmt.Sprintf("%019d", math.MaxInt64-t.UnixNano())
+}
+
+// ===================================================================
+// recordInvocation: count an invocation of a service under the
+// transaction's own key of the invoke~service~txid index
+// ===================================================================
+func recordInvocation(stub shim.ChaincodeStubInterface, service_name string) error {
+	index_key, err := stub.CreateCompositeKey(InvokeIndex, []string{service_name, stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(index_key, []byte{0x00})
+}
+
+// countInvocations adds up the invocations of a service recorded in
+// the invoke~service~txid index
+func countInvocations(stub shim.ChaincodeStubInterface, service_name string) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(InvokeIndex, []string{service_name})
+	if err != nil {
+		return 0, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		_, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// takeInvocations deletes the invocations of a service recorded in the
+// invoke~service~txid index and returns how many there were
+func takeInvocations(stub shim.ChaincodeStubInterface, service_name string) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(InvokeIndex, []string{service_name})
+	if err != nil {
+		return 0, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// moveInvocations moves the invoke~service~txid entries of a renamed
+// service to its new name, each under the same txid
+func moveInvocations(stub shim.ChaincodeStubInterface, old_name string, new_name string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(InvokeIndex, []string{old_name})
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		new_key, err := stub.CreateCompositeKey(InvokeIndex, []string{new_name, attributes[1]})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(new_key, queryResponse.Value)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===================================================================
+// checkNotBanned: make sure the user registered with an address isn't
+// banned; an address without a user isn't banned
+// ===================================================================
+func checkNotBanned(stub shim.ChaincodeStubInterface, address string) error {
+	userJSON, err := getUserByAddress(stub, address)
+	if err != nil {
+		return err
+	}
+	if userJSON != nil && userJSON.Banned {
+		return fmt.Errorf("This user is banned: %s", userJSON.Name)
+	}
+	return nil
+}
+
+// ===================================================================
+// walkComposition: add a service and, for a mashup, its components to
+// the graph; on_path holds the mashups being walked, to spot cycles
+// ===================================================================
+func walkComposition(stub shim.ChaincodeStubInterface, graph *compositionGraph, serviceJSON service, on_path map[string]bool, depth int) error {
+	graph.seen[serviceJSON.Name] = true
+	graph.Nodes = append(graph.Nodes, compositionNode{Name: serviceJSON.Name, IsMashup: serviceJSON.IsMashup})
+	if !serviceJSON.IsMashup {
+		return nil
+	}
+	if depth >= MaxCompositionDepth {
+		graph.Truncated = true
+		return nil
+	}
+
+	on_path[serviceJSON.Name] = true
+	defer delete(on_path, serviceJSON.Name)
+	for _, name := range sortedKeys(serviceJSON.Composition) {
+		graph.Edges = append(graph.Edges, compositionEdge{From: serviceJSON.Name, To: name})
+		if on_path[name] {
+			graph.Cycle = true
+			continue
+		}
+		if graph.seen[name] {
+			continue
+		}
+		componentAsBytes, err := stub.GetState(ServicePrefix + name)
+		if err != nil {
+			return fmt.Errorf("Fail to get service: %s", err.Error())
+		}
+		if componentAsBytes == nil {
+			graph.seen[name] = true
+			graph.Nodes = append(graph.Nodes, compositionNode{Name: name, Missing: true})
+			continue
+		}
+		var componentJSON service
+		err = json.Unmarshal(componentAsBytes, &componentJSON)
+		if err != nil {
+			return fmt.Errorf("Error unmarshal service bytes: %s", name)
+		}
+		err = walkComposition(stub, graph, componentJSON, on_path, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===================================================================
+// recordCoOccurrence: count, in the co-occurrence document of each
+// plain service among names, every other plain service composited
+// along with it; returns the names of the updated services
+// ===================================================================
+func recordCoOccurrence(stub shim.ChaincodeStubInterface, names []string) ([]string, error) {
+	plain := make(map[string]service)
+	for _, name := range names {
+		serviceAsBytes, err := stub.GetState(ServicePrefix + name)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to get service: %s", err.Error())
+		}
+		if serviceAsBytes == nil {
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal service bytes: %s", name)
+		}
+		if !serviceJSON.IsMashup {
+			plain[name] = serviceJSON
+		}
+	}
+	if len(plain) < 2 {
+		return nil, nil
+	}
+
+	var updated []string
+	for _, name := range names {
+		serviceJSON, ok := plain[name]
+		if !ok {
+			continue
+		}
+		if serviceJSON.Composition == nil {
+			serviceJSON.Composition = make(map[string]int)
+		}
+		for other := range plain {
+			if other != name {
+				serviceJSON.Composition[other]++
+			}
+		}
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(ServicePrefix+name, serviceJSONasBytes)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, name)
+	}
+	return updated, nil
+}
+
+// ===================================================================
+// recordEarning: add an amount paid to a user to their total earned in
+// that token; a user is credited at most once per token in a transaction
+// ===================================================================
+func recordEarning(stub shim.ChaincodeStubInterface, user_name string, token string, amount *big.Int) error {
+	earned_key, err := stub.CreateCompositeKey(EarnedIndex, []string{user_name, token})
+	if err != nil {
+		return err
+	}
+	earnedAsBytes, err := stub.GetState(earned_key)
+	if err != nil {
+		return fmt.Errorf("Fail to get the earnings of %s: %s", user_name, err.Error())
+	}
+	earned := big.NewInt(0)
+	if earnedAsBytes != nil {
+		earned.SetString(string(earnedAsBytes), 10)
+	}
+	earned.Add(earned, amount)
+	return stub.PutState(earned_key, []byte(earned.String()))
+}
+
+// recordRevenue: add a protocol fee to the revenue collected in its token
+func recordRevenue(stub shim.ChaincodeStubInterface, token string, amount *big.Int) error {
+	revenue, err := getRevenue(stub)
+	if err != nil {
+		return err
+	}
+	total := big.NewInt(0)
+	total.SetString(revenue[token], 10)
+	revenue[token] = total.Add(total, amount).String()
+	revenueAsBytes, err := json.Marshal(revenue)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(StatsRevenueKey, revenueAsBytes)
+}
+
+// getRevenue: the protocol revenue collected so far, by token
+func getRevenue(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	revenueAsBytes, err := stub.GetState(StatsRevenueKey)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get the protocol revenue: %s", err.Error())
+	}
+	revenue := make(map[string]string)
+	if revenueAsBytes != nil {
+		err = json.Unmarshal(revenueAsBytes, &revenue)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal the protocol revenue.")
+		}
+	}
+	return revenue, nil
+}
+
+// getEarnings: the totals a user earned, by token
+func getEarnings(stub shim.ChaincodeStubInterface, user_name string) (map[string]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(EarnedIndex, []string{user_name})
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	earnings := make(map[string]string)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) != 2 {
+			return nil, fmt.Errorf("Malformed earnings key: %s", queryResponse.Key)
+		}
+		earnings[attributes[1]] = string(queryResponse.Value)
+	}
+	return earnings, nil
+}
+
+// ===================================================================
+// isTokenRecord: whether a record stored under a bare key is a Token
+// issued through initAccount, which are keyed by their name
+// ===================================================================
+func isTokenRecord(key string, value []byte) bool {
+	var tokenJSON Token
+	err := json.Unmarshal(value, &tokenJSON)
+	return err == nil && tokenJSON.Name != "" && tokenJSON.Name == key
+}
+
+// importAddressIndex: index an imported user's address unless another
+// user already holds it, as registerUser does
+func importAddressIndex(stub shim.ChaincodeStubInterface, userJSON user, written map[string]bool) error {
+	address_key := addressKey(userJSON.Address)
+	indexedAsBytes, err := stub.GetState(address_key)
+	if err != nil {
+		return fmt.Errorf("Fail to get the address index: %s", err.Error())
+	}
+	if indexedAsBytes != nil || written[address_key] {
+		return nil
+	}
+	written[address_key] = true
+	return stub.PutState(address_key, []byte(userJSON.Name))
+}
+
+// ===================================================================
+// getMashupsUsing: collect the mashups whose composition contains
+// the service, through the component~mashup index; mashups created
+// before the index aren't listed
+// ===================================================================
+func getMashupsUsing(stub shim.ChaincodeStubInterface, service_name string) ([]service, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ComponentIndex, []string{service_name})
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	mashups := []service{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) != 2 {
+			return nil, fmt.Errorf("Malformed index key: %s", queryResponse.Key)
+		}
+		mashupAsBytes, err := stub.GetState(ServicePrefix + attributes[1])
+		if err != nil {
+			return nil, fmt.Errorf("Fail to get service: %s", err.Error())
+		}
+		if mashupAsBytes == nil {
+			continue
+		}
+		var mashupJSON service
+		err = json.Unmarshal(mashupAsBytes, &mashupJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal service bytes: %s", attributes[1])
+		}
+		if mashupJSON.IsMashup && mashupJSON.Name != service_name && mashupJSON.Composition[service_name] != 0 {
+			mashups = append(mashups, mashupJSON)
+		}
+	}
+	return mashups, nil
+}
+
+// getIndexedUser: the user the ADDR_ index maps an address to, nil if
+// the address isn't indexed
+func getIndexedUser(stub shim.ChaincodeStubInterface, address string) (*user, error) {
+	indexedAsBytes, err := stub.GetState(addressKey(address))
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get address index: %s", err.Error())
+	}
+	if indexedAsBytes == nil {
+		return nil, nil
+	}
+	userAsBytes, err := stub.GetState(UserPrefix + string(indexedAsBytes))
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get user: %s", err.Error())
+	}
+	if userAsBytes == nil {
+		return nil, nil
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshal user bytes.")
+	}
+	if !sameAddress(userJSON.Address, address) {
+		return nil, nil
+	}
+	return &userJSON, nil
+}
+
+// moveComponentIndex moves a mashup's component~mashup entry from a
+// renamed component to its new name; new_name empty only drops it
+func moveComponentIndex(stub shim.ChaincodeStubInterface, mashup_name string, old_name string, new_name string) error {
+	old_key, err := stub.CreateCompositeKey(ComponentIndex, []string{old_name, mashup_name})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(old_key)
+	if err != nil || new_name == "" {
+		return err
+	}
+	new_key, err := stub.CreateCompositeKey(ComponentIndex, []string{new_name, mashup_name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(new_key, []byte{0x00})
+}
+
+// ===================================================================
+// getUserByAddress: find the user registered with an address, through
+// the ADDR_ index, falling back to a scan for users registered before
+// the index existed; nil if no user owns the address
+// ===================================================================
+func getUserByAddress(stub shim.ChaincodeStubInterface, address string) (*user, error) {
+	userJSON, err := getIndexedUser(stub, address)
+	if err != nil || userJSON != nil {
+		return userJSON, err
+	}
+
+	users, err := getAllUsers(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, userJSON := range users {
+		if sameAddress(userJSON.Address, address) {
+			return &userJSON, nil
+		}
+	}
+	return nil, nil
+}
+
+// ===================================================================
+// checkServiceQuota: make sure the developer, a user or the address
+// of a mashup creator without one, can own `adding` more active
+// services and mashups; invalidated ones don't count against SERVICE_QUOTA
+// ===================================================================
+func checkServiceQuota(stub shim.ChaincodeStubInterface, address string, userJSON *user, adding int) error {
+	quota, err := getConfigInt(stub, ConfigQuota)
+	if err != nil {
+		return err
+	}
+	if quota == 0 {
+		return nil
+	}
+
+	services, err := getDeveloperServices(stub, address, userJSON)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, s := range services {
+		if s.Status != S_Invalid {
+			count++
+		}
+	}
+	developer := address
+	if userJSON != nil {
+		developer = userJSON.Name
+	}
+	if count+adding > quota {
+		return fmt.Errorf("Service quota reached for %s: %d active services, limit %d.", developer, count, quota)
+	}
+	return nil
+}
+
+// emitEvent sets the transaction's chaincode event; a transaction
+// carries a single event, so emit it last
+func emitEvent(stub shim.ChaincodeStubInterface, event_name string, name string, developer string) error {
+	payload, err := json.Marshal(lifecycleEvent{Name: name, Developer: developer})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(event_name, payload)
+}
+
+// markUserActive records an action of the user, restarting its contribution decay
+func markUserActive(userJSON *user, tNow time.Time) {
+	userJSON.LastActive = formatTime(tNow)
+	userJSON.DecayedPeriods = 0
+}
+
+// decayBy decays a contribution by DECAY_PERCENT `periods` times
+func decayBy(contribution int, periods int, decay_pct int) int {
+	for i := 0; i < periods && contribution > 0; i++ {
+		contribution = contribution * (100 - decay_pct) / 100
+	}
+	return contribution
+}
+
+// ===================================================================
+// setContribution: set a user's recomputed contribution, decayed for
+// every DECAY_PERIOD elapsed since the user was last active, and
+// record those periods as applied, so that decayContributions goes on
+// from there instead of losing or repeating the decay
+// ===================================================================
+func setContribution(stub shim.ChaincodeStubInterface, userJSON *user, contribution int) error {
+	userJSON.Contribution = contribution
+	userJSON.DecayedPeriods = 0
+	decay_pct, err := getConfigInt(stub, ConfigDecayPct)
+	if err != nil {
+		return err
+	}
+	decay_days, err := getConfigInt(stub, ConfigDecayDays)
+	if err != nil {
+		return err
+	}
+	tActive, err := parseTime(userJSON.LastActive)
+	if decay_pct == 0 || err != nil {
+		return nil
+	}
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	periods := int(tNow.Sub(tActive) / (time.Duration(decay_days) * 24 * time.Hour))
+	if periods > 0 {
+		userJSON.Contribution = decayBy(contribution, periods, decay_pct)
+		userJSON.DecayedPeriods = periods
+	}
+	return nil
+}
+
+// touchUser marks the user active and stores the user
+func touchUser(stub shim.ChaincodeStubInterface, userJSON user) error {
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	markUserActive(&userJSON, tNow)
+	userJSONasBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(UserPrefix+userJSON.Name, userJSONasBytes)
+}
+
+// seqKey builds a key whose sequence number sorts in numeric order
+func seqKey(prefix string, seq int) string {
+	return fmt.Sprintf("%s%010d", prefix, seq)
+}
+
+// ===================================================================
+// recordTreasuryEntry: append a movement of funds to the treasury ledger
+//
+// the entry is keyed on the transaction's timestamp and txid, so that
+// concurrent transactions never write the same key and the ledger
+// still reads in time order; a transaction records one entry at most
+// ===================================================================
+func recordTreasuryEntry(stub shim.ChaincodeStubInterface, direction string, source string, target string,
+	amount *big.Int, token_type string, reason string) error {
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	tx_id := stub.GetTxID()
+	entry := treasuryEntry{0, tx_id, direction, source, target, amount.String(), token_type, reason, formatTime(tNow)}
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(treasuryKey(tNow, tx_id), entryAsBytes)
+}
+
+// treasuryKey is the key of a treasury ledger entry; it sorts in time
+// order, after the entries keyed by seqKey
+func treasuryKey(tNow time.Time, tx_id string) string {
+	return TreasuryPrefix + indexTime(tNow) + "_" + tx_id
+}
+
+// getTreasuryEntries loads the treasury ledger, oldest first
+func getTreasuryEntries(stub shim.ChaincodeStubInterface) ([]treasuryEntry, error) {
+	resultsIterator, err := stub.GetStateByRange(TreasuryPrefix, TreasuryPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var entries []treasuryEntry
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var entry treasuryEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal treasury entry: %s", queryResponse.Key)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// getServiceComments reads the comments on a service, in txid order
+func getServiceComments(stub shim.ChaincodeStubInterface, service_name string) ([]comment, error) {
+	prefix := CommentPrefix + service_name + "_"
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var comments []comment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var commentJSON comment
+		err = json.Unmarshal(queryResponse.Value, &commentJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal comment bytes: %s", queryResponse.Key)
+		}
+		// the range also covers the comments of services named <service>_...
+		if commentJSON.Service != service_name {
+			continue
+		}
+		comments = append(comments, commentJSON)
+	}
+	return comments, nil
+}
+
+// moveComments moves the comments of a renamed service to its new name
+func moveComments(stub shim.ChaincodeStubInterface, old_name string, new_name string) error {
+	comments, err := getServiceComments(stub, old_name)
+	if err != nil {
+		return err
+	}
+	for _, commentJSON := range comments {
+		commentJSON.Service = new_name
+		commentAsBytes, err := json.Marshal(commentJSON)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(CommentPrefix+new_name+"_"+commentJSON.TxId, commentAsBytes)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(CommentPrefix + old_name + "_" + commentJSON.TxId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAccess logs a paid access to a service's co-occurrence; a
+// transaction performs at most one, so the txid keeps the key unique
+func recordAccess(stub shim.ChaincodeStubInterface, service_name string, payer string, amount *big.Int) error {
+	tNow, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	tx_id := stub.GetTxID()
+	entry := accessEntry{service_name, payer, amount.String(), tx_id, formatTime(tNow)}
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(AccessPrefix+service_name+"_"+indexTime(tNow)+"_"+tx_id, entryAsBytes)
+}
+
+// getAccessLog reads the paid accesses to a service, oldest first
+func getAccessLog(stub shim.ChaincodeStubInterface, service_name string) ([]accessEntry, error) {
+	prefix := AccessPrefix + service_name + "_"
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var entries []accessEntry
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var entry accessEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal access entry: %s", queryResponse.Key)
+		}
+		// the range also covers the accesses to services named <service>_...
+		if entry.Service != service_name {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// moveAccessLog moves the access log of a renamed service
+func moveAccessLog(stub shim.ChaincodeStubInterface, old_name string, new_name string) error {
+	prefix := AccessPrefix + old_name + "_"
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		var entry accessEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return fmt.Errorf("Error unmarshal access entry: %s", queryResponse.Key)
+		}
+		// the range also covers the accesses to services named <service>_...
+		if entry.Service != old_name {
+			continue
+		}
+		entry.Service = new_name
+		entryAsBytes, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(AccessPrefix+new_name+"_"+strings.TrimPrefix(queryResponse.Key, prefix), entryAsBytes)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===================================================================
+// computeContribution: score a user's contribution over the services
+// the developer~service index lists for the user, weighting with the
+// CONTRIB_* configs
+//   - each of the user's published services
+//   - each mashup compositing one of the user's services
+//   - each vote or comment the user's services received
+//
+// writes made earlier in the transaction aren't visible to GetState,
+// so the services stored by the transaction are passed as pending
+// ===================================================================
+func computeContribution(stub shim.ChaincodeStubInterface, userJSON user, pending []service) (int, error) {
+	weights := make(map[string]int)
+	for _, config_name := range []string{ConfigWPublished, ConfigWComposed, ConfigWReview} {
+		weight, err := getConfigInt(stub, config_name)
+		if err != nil {
+			return 0, err
+		}
+		weights[config_name] = weight
+	}
+
+	// mashups record their creator's address as developer
+	developed, err := getDeveloperServices(stub, userJSON.Address, &userJSON)
+	if err != nil {
+		return 0, err
+	}
+	owned := make(map[string]service)
+	for _, s := range developed {
+		owned[s.Name] = s
+	}
+	for _, s := range pending {
+		if s.Developer == userJSON.Name || sameAddress(s.Developer, userJSON.Address) {
+			owned[s.Name] = s
+		}
+	}
+
+	published, composed, reviews := 0, 0, 0
+	for name, s := range owned {
+		if s.Status == S_Available || s.Status == S_Deprecated {
+			published++
+		}
+		comments, err := getServiceComments(stub, name)
+		if err != nil {
+			return 0, err
+		}
+		reviews += s.Up + s.Down + len(comments)
+		mashups, err := getMashupsUsing(stub, name)
+		if err != nil {
+			return 0, err
+		}
+		composed += len(mashups)
+	}
+	for _, s := range pending {
+		if s.IsMashup {
+			for k := range s.Composition {
+				if _, ok := owned[k]; ok {
+					composed++
+				}
+			}
+		}
+	}
+
+	return published*weights[ConfigWPublished] + composed*weights[ConfigWComposed] +
+		reviews*weights[ConfigWReview], nil
+}
+
+// parseTags splits comma-separated tags, lowercased and without
+// duplicates, and enforces MaxServiceTags
+func parseTags(tags_str string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(tags_str, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxServiceTags {
+		return nil, fmt.Errorf("A service can't have more than %d tags, got %d.", MaxServiceTags, len(tags))
+	}
+	return tags, nil
+}
+
+// versionKey is the key of a service's past version
+func versionKey(service_name string, version int) string {
+	return seqKey(VersionPrefix+service_name+"_", version)
+}
+
+// moveServiceVersions moves the past versions of a renamed service
+func moveServiceVersions(stub shim.ChaincodeStubInterface, old_name string, new_name string) error {
+	prefix := VersionPrefix + old_name + "_"
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		// the range also covers the versions of services named <service>_...
+		version, err := strconv.Atoi(strings.TrimPrefix(queryResponse.Key, prefix))
+		if err != nil {
+			continue
+		}
+		err = stub.PutState(versionKey(new_name, version), queryResponse.Value)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===================================================================
+// purgeServiceData: delete the votes, comments, past versions and
+// access logs of removed services and drop them from the co-occurrence
+// documents of the services composited along with them, so that a
+// service later registered under one of their names starts afresh;
+// returns the names of the updated services
+// ===================================================================
+func purgeServiceData(stub shim.ChaincodeStubInterface, removed []service) ([]string, error) {
+	removed_names := make(map[string]bool)
+	for _, s := range removed {
+		removed_names[s.Name] = true
+	}
+
+	// STEP 1: delete the keys of each removed service; the ranges also
+	// cover the keys of services named <service>_..., which are kept
+	partners := make(map[string][]string)
+	for _, s := range removed {
+		service_name := s.Name
+		err := deleteVotes(stub, service_name)
+		if err != nil {
+			return nil, err
+		}
+		err = deleteByPrefix(stub, CommentPrefix+service_name+"_", func(suffix string, value []byte) bool {
+			var commentJSON comment
+			return json.Unmarshal(value, &commentJSON) == nil && commentJSON.Service == service_name
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = deleteByPrefix(stub, VersionPrefix+service_name+"_", func(suffix string, value []byte) bool {
+			_, err := strconv.Atoi(suffix)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = deleteByPrefix(stub, AccessPrefix+service_name+"_", func(suffix string, value []byte) bool {
+			var entry accessEntry
+			return json.Unmarshal(value, &entry) == nil && entry.Service == service_name
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = takeInvocations(stub, service_name)
+		if err != nil {
+			return nil, err
+		}
+		if !s.IsMashup {
+			for other := range s.Composition {
+				if !removed_names[other] {
+					partners[other] = append(partners[other], service_name)
+				}
+			}
+		}
+	}
+
+	// STEP 2: update each partner's co-occurrence document once
+	partner_names := make([]string, 0, len(partners))
+	for name := range partners {
+		partner_names = append(partner_names, name)
+	}
+	sort.Strings(partner_names)
+	var updated []string
+	for _, name := range partner_names {
+		serviceAsBytes, err := stub.GetState(ServicePrefix + name)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to get service: %s", err.Error())
+		}
+		if serviceAsBytes == nil {
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal service bytes: %s", name)
+		}
+		if serviceJSON.IsMashup {
+			continue
+		}
+		for _, removed_name := range partners[name] {
+			delete(serviceJSON.Composition, removed_name)
+		}
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(ServicePrefix+name, serviceJSONasBytes)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, name)
+	}
+	return updated, nil
+}
+
+// deleteByPrefix deletes the keys under prefix whose suffix and value
+// belong to the caller
+func deleteByPrefix(stub shim.ChaincodeStubInterface, prefix string, belongs func(suffix string, value []byte) bool) error {
+	resultsIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if !belongs(strings.TrimPrefix(queryResponse.Key, prefix), queryResponse.Value) {
+			continue
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVotes deletes the votes on a service recorded in the
+// vote~service~voter index
+func deleteVotes(stub shim.ChaincodeStubInterface, service_name string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(VoteIndex, []string{service_name})
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveVotes moves the votes on a renamed service to its new name, so
+// that its voters still can't vote twice
+func moveVotes(stub shim.ChaincodeStubInterface, old_name string, new_name string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(VoteIndex, []string{old_name})
+	if err != nil {
+		return rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		new_key, err := stub.CreateCompositeKey(VoteIndex, []string{new_name, attributes[1]})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(new_key, queryResponse.Value)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeError flags the failure of a range or rich query caused by a
+// state database that doesn't support it with ERR_RANGE_UNSUPPORTED,
+// so that clients can fall back to per-key queries
+func rangeError(err error) error {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "not supported") || strings.Contains(msg, "unsupported") ||
+		strings.Contains(msg, "not implemented") {
+		return fmt.Errorf("%s: %s", ERR_RANGE_UNSUPPORTED, err.Error())
+	}
+	return err
+}
+
+// sortedKeys returns the keys of a composition map in order,
+// so that iterating it is deterministic across endorsers
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ===================================================================
+// getTxTime: the transaction's timestamp, identical on every endorser
+// ===================================================================
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTime, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Fail to get the transaction timestamp.")
+	}
+	return time.Unix(txTime.Seconds, int64(txTime.Nanos)).UTC(), nil
+}
+
+// statusTransitions lists the statuses a service may move to from each
+// status; an invalidated service only comes back through revalidateService
+var statusTransitions = map[string][]string{
+	S_Created:    {S_Available},
+	S_Available:  {S_Invalid, S_Deprecated},
+	S_Deprecated: {S_Available, S_Invalid},
+	S_Invalid:    {S_Available},
+}
+
+// checkStatusTransition makes sure a service may move from one status to another
+func checkStatusTransition(from string, to string) error {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("Illegal status transition: the service is %s, it can't become %s.", from, to)
+}
+
+// isServiceStatus checks whether status is one of the defined service status
+func isServiceStatus(status string) bool {
+	switch status {
+	case S_Created, S_Available, S_Invalid, S_Deprecated:
+		return true
+	}
+	return false
+}
+
+// formatTime formats a time stored on the ledger (CreatedTime, LastActive...)
+// in RFC3339, so that stored times sort lexicographically
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTimeArg parses a time argument given in RFC3339 or unix seconds
+func parseTimeArg(tString string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(tString, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, tString)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Expecting RFC3339 or unix seconds for time: %s", tString)
+	}
+	return t, nil
+}
+
+// parseTime parses a time stored on the ledger; records written before
+// the switch to RFC3339 still hold time.UnixDate times
+func parseTime(tString string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, tString)
+	if err != nil {
+		return time.Parse(time.UnixDate, tString)
+	}
+	return t, nil
+}
+
+// ===================================================================
+// getAllServices: load every service record stored under ServicePrefix
+// ===================================================================
+func getAllServices(stub shim.ChaincodeStubInterface) ([]service, error) {
+	resultsIterator, err := stub.GetStateByRange(ServicePrefix, ServicePrefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, rangeError(err)
+	}
+	defer resultsIterator.Close()
+
+	var services []service
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshal service bytes: %s", queryResponse.Key)
+		}
+		services = append(services, serviceJSON)
+	}
+	return services, nil
+}
+
+// ===================================================================
+// checkCompositionCycle: walk a composited service's own composition
+// and make sure the mashup being created never appears in it
+//
+// checked records the deepest depth each mashup passed at: reached
+// again no deeper, its shared sub-composition isn't walked twice
+// ===================================================================
+func checkCompositionCycle(stub shim.ChaincodeStubInterface, mashup_name string, serviceJSON service, depth int, checked map[string]int) error {
+	if !serviceJSON.IsMashup || checked[serviceJSON.Name] >= depth {
+		return nil
+	}
+	if depth > MaxCompositionDepth {
+		return fmt.Errorf("The composition is too deep at %s, more than %d nested mashups.", serviceJSON.Name, MaxCompositionDepth)
+	}
+	for _, name := range sortedKeys(serviceJSON.Composition) {
+		if name == mashup_name {
+			return fmt.Errorf("The composition would create a cycle through %s.", serviceJSON.Name)
+		}
+		componentAsBytes, err := stub.GetState(ServicePrefix + name)
+		if err != nil {
+			return fmt.Errorf("Fail to get service: %s", err.Error())
+		}
+		// dangling components are reconcileMashupComposition's business
+		if componentAsBytes == nil {
+			continue
+		}
+		var componentJSON service
+		err = json.Unmarshal(componentAsBytes, &componentJSON)
+		if err != nil {
+			return fmt.Errorf("Error unmarshal service bytes: %s", name)
+		}
+		err = checkCompositionCycle(stub, mashup_name, componentJSON, depth+1, checked)
+		if err != nil {
+			return err
+		}
+	}
+	checked[serviceJSON.Name] = depth
+	return nil
+}
+
+// ======================================================
+// requireMashup: make sure a mashup-only operation is
+// applied to a mashup rather than a conventional service
+// ======================================================
+func requireMashup(serviceJSON service) error {
+	if !serviceJSON.IsMashup {
+		return fmt.Errorf("This service is not a mashup: %s", serviceJSON.Name)
+	}
+	return nil
+}
+
+// ======================================================
+// requireNotMashup: make sure a service-only operation
+// (e.g. co-occurrence documents) is not applied to a mashup
+// ======================================================
+func requireNotMashup(serviceJSON service) error {
+	if serviceJSON.IsMashup {
+		return fmt.Errorf("This service is a mashup: %s", serviceJSON.Name)
+	}
+	return nil
 }
@@ -0,0 +1,2806 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	"github.com/inklabsfoundation/inkchain/core/wallet"
+	"github.com/inklabsfoundation/inkchain/protos/ledger/queryresult"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+const (
+	ADMIN = "07caf88941eafcaaa3370657fccc261acb75dfba"
+	ALICE = "a5ff00eb44bf19d5dfbde501c90e286badb58df4"
+	BOB   = "3c97f146e8de9807ef723538521fcecd5f64c79a"
+
+	TREASURY = "cccccccccccccccccccccccccccccccccccccccc"
+)
+
+// ====================================================================
+// mockStub: an in-memory ledger for the chaincode tests. It embeds the
+// stub interface so only the calls the chaincode makes are implemented.
+// Like the peer, reads don't see the transaction's own writes, and the
+// writes and token transfers are only committed when it succeeds.
+// ====================================================================
+type mockStub struct {
+	shim.ChaincodeStubInterface
+
+	cc       *serviceChaincode
+	state    map[string][]byte
+	history  map[string][]*queryresult.KeyModification
+	balances map[string]map[string]*big.Int
+	events   map[string][]byte
+	now      int64
+	noRange  bool
+	noRich   bool
+	noScan   bool // fail a range over every service
+	reads    int  // GetState calls
+
+	sender  string
+	args    []string
+	txCount int
+	txID    string
+	pending map[string][]byte
+	deleted map[string]bool
+}
+
+func newMockStub(cc *serviceChaincode) *mockStub {
+	return &mockStub{
+		cc:       cc,
+		state:    map[string][]byte{},
+		history:  map[string][]*queryresult.KeyModification{},
+		balances: map[string]map[string]*big.Int{},
+		events:   map[string][]byte{},
+		now:      1500000000,
+	}
+}
+
+// run executes f as one transaction sent by sender
+func (m *mockStub) run(sender string, f func() pb.Response) pb.Response {
+	m.sender = sender
+	m.txCount++
+	m.txID = "tx" + strconv.Itoa(m.txCount)
+	m.now++
+	m.pending = map[string][]byte{}
+	m.deleted = map[string]bool{}
+	m.events = map[string][]byte{}
+	saved := m.copyBalances()
+
+	res := f()
+	if res.Status != shim.OK {
+		m.balances = saved
+		return res
+	}
+
+	keys := []string{}
+	for k := range m.pending {
+		keys = append(keys, k)
+	}
+	for k := range m.deleted {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		mod := &queryresult.KeyModification{TxId: m.txID, Timestamp: &timestamp.Timestamp{Seconds: m.now}}
+		if m.deleted[k] {
+			delete(m.state, k)
+			mod.IsDelete = true
+		} else {
+			m.state[k] = m.pending[k]
+			mod.Value = m.pending[k]
+		}
+		m.history[k] = append(m.history[k], mod)
+	}
+	return res
+}
+
+func (m *mockStub) init(sender string) pb.Response {
+	m.args = nil
+	return m.run(sender, func() pb.Response { return m.cc.Init(m) })
+}
+
+func (m *mockStub) invoke(sender string, args ...string) pb.Response {
+	m.args = args
+	return m.run(sender, func() pb.Response { return m.cc.Invoke(m) })
+}
+
+func (m *mockStub) copyBalances() map[string]map[string]*big.Int {
+	c := map[string]map[string]*big.Int{}
+	for addr, balance := range m.balances {
+		c[addr] = map[string]*big.Int{}
+		for token, amount := range balance {
+			c[addr][token] = new(big.Int).Set(amount)
+		}
+	}
+	return c
+}
+
+func (m *mockStub) balance(addr, token string) *big.Int {
+	if m.balances[addr] == nil {
+		m.balances[addr] = map[string]*big.Int{}
+	}
+	if m.balances[addr][token] == nil {
+		m.balances[addr][token] = big.NewInt(0)
+	}
+	return m.balances[addr][token]
+}
+
+func (m *mockStub) issueToken(addr, token string, amount int64) {
+	b := m.balance(addr, token)
+	b.Add(b, big.NewInt(amount))
+}
+
+func (m *mockStub) sortedKeys() []string {
+	keys := []string{}
+	for k := range m.state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *mockStub) GetArgs() [][]byte {
+	args := [][]byte{}
+	for _, a := range m.args {
+		args = append(args, []byte(a))
+	}
+	return args
+}
+
+func (m *mockStub) GetStringArgs() []string { return m.args }
+
+func (m *mockStub) GetFunctionAndParameters() (string, []string) {
+	if len(m.args) == 0 {
+		return "", nil
+	}
+	return m.args[0], m.args[1:]
+}
+
+func (m *mockStub) GetTxID() string { return m.txID }
+
+func (m *mockStub) GetState(key string) ([]byte, error) {
+	m.reads++
+	return m.state[key], nil
+}
+
+func (m *mockStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	delete(m.deleted, key)
+	m.pending[key] = value
+	return nil
+}
+
+func (m *mockStub) DelState(key string) error {
+	delete(m.pending, key)
+	m.deleted[key] = true
+	return nil
+}
+
+func (m *mockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if m.noRange {
+		return nil, errors.New("GetStateByRange not supported for this state database")
+	}
+	if m.noScan && startKey == ServicePrefix {
+		return nil, errors.New("unexpected scan from " + startKey)
+	}
+	// like fabric's empty start key, a plain range never reaches the composite keys
+	composite := strings.HasPrefix(startKey, compositeKeyNamespace)
+	it := &mockIterator{}
+	for _, k := range m.sortedKeys() {
+		if strings.HasPrefix(k, compositeKeyNamespace) != composite {
+			continue
+		}
+		if (startKey == "" || k >= startKey) && (endKey == "" || k < endKey) {
+			it.items = append(it.items, &queryresult.KV{Key: k, Value: m.state[k]})
+		}
+	}
+	return it, nil
+}
+
+const compositeKeyNamespace = "\x00"
+
+func (m *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	key := compositeKeyNamespace + objectType + compositeKeyNamespace
+	for _, a := range attributes {
+		key += a + compositeKeyNamespace
+	}
+	return key, nil
+}
+
+func (m *mockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	parts := strings.Split(compositeKey, compositeKeyNamespace)
+	if len(parts) < 3 {
+		return "", nil, fmt.Errorf("not a composite key: %q", compositeKey)
+	}
+	parts = parts[1 : len(parts)-1]
+	return parts[0], parts[1:], nil
+}
+
+func (m *mockStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, _ := m.CreateCompositeKey(objectType, keys)
+	it := &mockIterator{}
+	for _, k := range m.sortedKeys() {
+		if strings.HasPrefix(k, prefix) {
+			it.items = append(it.items, &queryresult.KV{Key: k, Value: m.state[k]})
+		}
+	}
+	return it, nil
+}
+
+func (m *mockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if m.noRich {
+		return nil, errors.New("ExecuteQuery not supported for leveldb")
+	}
+	return &mockIterator{}, nil
+}
+
+func (m *mockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &mockHistoryIterator{items: m.history[key]}, nil
+}
+
+func (m *mockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: m.now}, nil
+}
+
+func (m *mockStub) SetEvent(name string, payload []byte) error {
+	m.events[name] = payload
+	return nil
+}
+
+func (m *mockStub) GetSender() (string, error) { return m.sender, nil }
+
+func (m *mockStub) Transfer(to string, balanceType string, amount *big.Int) error {
+	from := m.balance(m.sender, balanceType)
+	if from.Cmp(amount) < 0 {
+		return errors.New("insufficient balance")
+	}
+	from.Sub(from, amount)
+	dest := m.balance(to, balanceType)
+	dest.Add(dest, amount)
+	return nil
+}
+
+func (m *mockStub) GetAccount(address string) (*wallet.Account, error) {
+	if m.balances[address] == nil {
+		return nil, nil
+	}
+	return &wallet.Account{Balance: m.balances[address]}, nil
+}
+
+type mockIterator struct {
+	items []*queryresult.KV
+	next  int
+}
+
+func (it *mockIterator) HasNext() bool { return it.next < len(it.items) }
+
+func (it *mockIterator) Next() (*queryresult.KV, error) {
+	it.next++
+	return it.items[it.next-1], nil
+}
+
+func (it *mockIterator) Close() error { return nil }
+
+type mockHistoryIterator struct {
+	items []*queryresult.KeyModification
+	next  int
+}
+
+func (it *mockHistoryIterator) HasNext() bool { return it.next < len(it.items) }
+
+func (it *mockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	it.next++
+	return it.items[it.next-1], nil
+}
+
+func (it *mockHistoryIterator) Close() error { return nil }
+
+// ====================================================================
+// test helpers
+// ====================================================================
+func newStub(t *testing.T) *mockStub {
+	s := newMockStub(new(serviceChaincode))
+	for _, a := range []string{ADMIN, ALICE, BOB} {
+		s.issueToken(a, "INK", 1000)
+	}
+	if r := s.init(ADMIN); r.Status != shim.OK {
+		t.Fatalf("init: %s", r.Message)
+	}
+	return s
+}
+
+func ok(t *testing.T, r pb.Response) []byte {
+	t.Helper()
+	if r.Status != shim.OK {
+		t.Fatalf("expected success, got %s", r.Message)
+	}
+	return r.Payload
+}
+
+func bad(t *testing.T, r pb.Response) string {
+	t.Helper()
+	if r.Status == shim.OK {
+		t.Fatalf("expected an error, got %s", r.Payload)
+	}
+	return r.Message
+}
+
+// step is one invocation of a table-driven scenario
+type step struct {
+	sender string
+	args   []string
+	fail   bool
+}
+
+func call(sender string, args ...string) step { return step{sender: sender, args: args} }
+func fails(sender string, args ...string) step {
+	return step{sender: sender, args: args, fail: true}
+}
+
+// play runs the steps in order and stops at the first unexpected result
+func play(t *testing.T, s *mockStub, steps ...step) {
+	t.Helper()
+	for i, st := range steps {
+		r := s.invoke(st.sender, st.args...)
+		if st.fail && r.Status == shim.OK {
+			t.Fatalf("step %d %v: expected an error, got %s", i, st.args, r.Payload)
+		}
+		if !st.fail && r.Status != shim.OK {
+			t.Fatalf("step %d %v: expected success, got %s", i, st.args, r.Message)
+		}
+	}
+}
+
+func getSvc(t *testing.T, s *mockStub, name string) map[string]interface{} {
+	t.Helper()
+	b := s.state[ServicePrefix+name]
+	if b == nil {
+		return nil
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func getUser(t *testing.T, s *mockStub, name string) map[string]interface{} {
+	t.Helper()
+	b := s.state[UserPrefix+name]
+	if b == nil {
+		return nil
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func itoa64(i int64) string { return strconv.FormatInt(i, 10) }
+
+// TestNoPanicOnShortArgs calls every handler, directly and through Invoke,
+// with zero and one argument: each must return an error or succeed, never
+// panic and abort the transaction.
+func TestNoPanicOnShortArgs(t *testing.T) {
+	cc := new(serviceChaincode)
+	handlers := map[string]func(shim.ChaincodeStubInterface, []string) pb.Response{
+		"registerUser":                    cc.registerUser,
+		"removeUser":                      cc.removeUser,
+		"queryUser":                       cc.queryUser,
+		"queryUserByAddress":              cc.queryUserByAddress,
+		"queryBalance":                    cc.queryBalance,
+		"updateUser":                      cc.updateUser,
+		"recalculateContribution":         cc.recalculateContribution,
+		"queryTopContributors":            cc.queryTopContributors,
+		"verifyOwnership":                 cc.verifyOwnership,
+		"initAccount":                     cc.initAccount,
+		"registerService":                 cc.registerService,
+		"registerServiceBatch":            cc.registerServiceBatch,
+		"invalidateService":               cc.invalidateService,
+		"publishService":                  cc.publishService,
+		"queryService":                    cc.queryService,
+		"queryServiceVersion":             cc.queryServiceVersion,
+		"editService":                     cc.editService,
+		"createMashup":                    cc.createMashup,
+		"simulateMashup":                  cc.simulateMashup,
+		"queryServiceByRange":             cc.queryServiceByRange,
+		"getServiceHistory":               cc.getServiceHistory,
+		"queryServicesByStatus":           cc.queryServicesByStatus,
+		"queryServicesByType":             cc.queryServicesByType,
+		"queryServicesRich":               cc.queryServicesRich,
+		"setServiceTags":                  cc.setServiceTags,
+		"bulkAddTag":                      cc.bulkAddTag,
+		"bulkRemoveTag":                   cc.bulkRemoveTag,
+		"queryServicesByTag":              cc.queryServicesByTag,
+		"queryServiceByUser":              cc.queryServiceByUser,
+		"queryServicesByAddress":          cc.queryServicesByAddress,
+		"queryServicesByNames":            cc.queryServicesByNames,
+		"queryMashupsUsingService":        cc.queryMashupsUsingService,
+		"queryCoOccurrence":               cc.queryCoOccurrence,
+		"queryServicePaid":                cc.queryServicePaid,
+		"queryAccessLog":                  cc.queryAccessLog,
+		"getUserPortfolio":                cc.getUserPortfolio,
+		"queryServicesGroupedByDeveloper": cc.queryServicesGroupedByDeveloper,
+		"reconcileMashupComposition":      cc.reconcileMashupComposition,
+		"getCompositionGraph":             cc.getCompositionGraph,
+		"queryChangedSince":               cc.queryChangedSince,
+		"queryRecentServices":             cc.queryRecentServices,
+		"queryServicesCreatedBetween":     cc.queryServicesCreatedBetween,
+		"finalizeService":                 cc.finalizeService,
+		"deprecateService":                cc.deprecateService,
+		"revalidateService":               cc.revalidateService,
+		"setServicePrice":                 cc.setServicePrice,
+		"setServicePriority":              cc.setServicePriority,
+		"removeService":                   cc.removeService,
+		"reclaimService":                  cc.reclaimService,
+		"registryDigest":                  cc.registryDigest,
+		"capabilities":                    cc.capabilities,
+		"getLimits":                       cc.getLimits,
+		"getMarketplaceStats":             cc.getMarketplaceStats,
+		"rewardService":                   cc.rewardService,
+		"givesToken":                      cc.givesToken,
+		"invokeService":                   cc.invokeService,
+		"voteService":                     cc.voteService,
+		"commentService":                  cc.commentService,
+		"queryServiceComments":            cc.queryServiceComments,
+		"setConfig":                       cc.setConfig,
+		"setMashupIncentive":              cc.setMashupIncentive,
+		"setIncentiveToken":               cc.setIncentiveToken,
+		"setIncentiveAmount":              cc.setIncentiveAmount,
+		"setProtocolFee":                  cc.setProtocolFee,
+		"addAdmin":                        cc.addAdmin,
+		"removeAdmin":                     cc.removeAdmin,
+		"sweepStaleDrafts":                cc.sweepStaleDrafts,
+		"exportState":                     cc.exportState,
+		"importState":                     cc.importState,
+		"invalidateToken":                 cc.invalidateToken,
+		"findDuplicateDescriptions":       cc.findDuplicateDescriptions,
+		"decayContributions":              cc.decayContributions,
+		"grantFromTreasury":               cc.grantFromTreasury,
+		"queryTreasuryBalance":            cc.queryTreasuryBalance,
+		"queryTreasuryLog":                cc.queryTreasuryLog,
+	}
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	ok(t, s.invoke(ALICE, "registerService", "x", "t", "d", "alice"))
+	for name, handler := range handlers {
+		for _, args := range [][]string{{}, {"x"}} {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s%v panicked: %v", name, args, r)
+					}
+				}()
+				s.run(ADMIN, func() pb.Response { return handler(s, args) })
+				s.invoke(ADMIN, append([]string{name}, args...)...)
+			}()
+		}
+	}
+}
+
+func TestBasic(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "queryService", "s1"),
+		call(BOB, "queryServiceByRange", "", ""),
+	)
+}
+
+func TestSweep(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "old", "t", "d", "alice"),
+		call(ALICE, "registerService", "ref", "t", "d", "alice"),
+		call(ADMIN, "createMashup", "m", "t", "d", "ref"),
+		fails(ALICE, "sweepStaleDrafts"),
+		fails(ADMIN, "setConfig", "DRAFT_TTL", "-1"),
+		call(ADMIN, "setConfig", "DRAFT_TTL", "1"),
+	)
+	s.now = 4000000000
+	if r := string(ok(t, s.invoke(ADMIN, "sweepStaleDrafts"))); r != `{"cleaned":2,"skipped":1}` {
+		t.Fatal(r)
+	}
+	if getSvc(t, s, "old") != nil || getSvc(t, s, "ref") == nil {
+		t.Fatal("only the unreferenced draft should be swept")
+	}
+}
+
+func TestByNames(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryServicesByNames", "s1", "nope")), &got)
+	if len(got) != 2 || got[0]["name"] != "s1" || got[1]["found"] != false || got[1]["name"] != "nope" {
+		t.Fatal(got)
+	}
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	s := newStub(t)
+	s.issueToken(ADMIN, "TOK", 1000)
+	ok(t, s.invoke(ADMIN, "initAccount", "TOK", "100", "10", ALICE))
+	if !strings.Contains(string(s.state["TOK"]), `"status":"issued"`) {
+		t.Fatal(string(s.state["TOK"]))
+	}
+	play(t, s,
+		fails(ADMIN, "initAccount", "TOK", "100", "10", ALICE),
+		fails(ALICE, "invalidateToken", "TOK"),
+		call(ADMIN, "invalidateToken", "TOK"),
+		fails(ADMIN, "invalidateToken", "TOK"),
+	)
+}
+
+func TestGrouped(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ADMIN, "registerUser", "admin", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "registerService", "s2", "t", "d", "bob"),
+		call(BOB, "publishService", "s2"),
+		call(ADMIN, "registerService", "s3", "t", "d", "admin"),
+		call(BOB, "createMashup", "m1", "t", "d", "s1", "s2"),
+		fails(ALICE, "queryServicesGroupedByDeveloper", "x"),
+		fails(ALICE, "queryServicesGroupedByDeveloper", "available", "x"),
+	)
+	for _, tc := range []struct {
+		args []string
+		want map[string]int
+	}{
+		{nil, map[string]int{"alice": 1, "bob": 2, "admin": 1}},
+		{[]string{"available"}, map[string]int{"alice": 1, "bob": 1}},
+	} {
+		got := map[string][]interface{}{}
+		json.Unmarshal(ok(t, s.invoke(ALICE, append([]string{"queryServicesGroupedByDeveloper"}, tc.args...)...)), &got)
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: got %v", tc.args, got)
+		}
+		for dev, n := range tc.want {
+			if len(got[dev]) != n {
+				t.Errorf("%v: %s has %d services, want %d", tc.args, dev, len(got[dev]), n)
+			}
+		}
+	}
+
+	// the groups are read within SCAN_LIMIT
+	play(t, s,
+		call(ADMIN, "setConfig", "SCAN_LIMIT", "3"),
+		fails(ALICE, "queryServicesGroupedByDeveloper"),
+		call(ADMIN, "setConfig", "SCAN_LIMIT", "4"),
+		call(ALICE, "queryServicesGroupedByDeveloper"),
+	)
+}
+
+func TestReconcile(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s2"),
+		call(ALICE, "createMashup", "m", "t", "d", "s1", "s2"),
+	)
+	delete(s.state, ServicePrefix+"s2")
+	play(t, s,
+		fails(ALICE, "reconcileMashupComposition", "s1"),
+		fails(BOB, "reconcileMashupComposition", "m"),
+	)
+	for _, tc := range []struct {
+		sender string
+		args   []string
+		want   string
+		left   int
+	}{
+		{ADMIN, nil, `[{"service":"s2","issue":"missing","action":"flagged"}]`, 2},
+		{ALICE, []string{"true"}, `[{"service":"s2","issue":"missing","action":"removed"}]`, 1},
+	} {
+		got := string(ok(t, s.invoke(tc.sender, append([]string{"reconcileMashupComposition", "m"}, tc.args...)...)))
+		if got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+		if n := len(getSvc(t, s, "m")["composition"].(map[string]interface{})); n != tc.left {
+			t.Errorf("composition has %d entries, want %d", n, tc.left)
+		}
+	}
+	if v := getSvc(t, s, "m")["composedVersions"].(map[string]interface{}); v["s2"] != nil || v["s1"] == nil {
+		t.Fatal(v)
+	}
+
+	// a rename is followed at once, nothing is left to reconcile
+	play(t, s,
+		call(ALICE, "editService", "s1", "Name", "s9"),
+	)
+	if got := string(ok(t, s.invoke(ALICE, "reconcileMashupComposition", "m"))); got != "[]" {
+		t.Fatal(got)
+	}
+}
+
+func TestMashupFee(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ADMIN, "setConfig", "MASHUP_FEE", "100"),
+		fails(BOB, "createMashup", "m", "t", "d", "s1"),
+		fails(ADMIN, "setConfig", "TREASURY", "ffff"),
+		call(ADMIN, "setConfig", "TREASURY", TREASURY),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+	if s.balances[TREASURY]["INK"].Int64() != 100 || s.balances[BOB]["INK"].Int64() != 890 {
+		t.Fatal(s.balances)
+	}
+	play(t, s,
+		call(ADMIN, "setConfig", "MASHUP_FEE", "885"),
+		fails(BOB, "createMashup", "m2", "t", "d", "s1"),
+	)
+}
+
+func TestChangedSince(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerService", "s3", "t", "d", "alice"),
+	)
+	snap := s.now
+	play(t, s,
+		call(ALICE, "editService", "s1", "Type", "x"),
+		call(ALICE, "registerService", "s4", "t", "d", "alice"),
+		call(ADMIN, "setConfig", "DRAFT_TTL", "0"),
+	)
+	s.now = 4000000000
+	ok(t, s.invoke(ADMIN, "sweepStaleDrafts"))
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryChangedSince", itoa64(snap))), &got)
+	changes := map[string]string{}
+	for _, c := range got {
+		changes[c["name"].(string)] = c["change"].(string)
+	}
+	want := map[string]string{"s1": "deleted", "s2": "deleted", "s3": "deleted", "s4": "deleted"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatal(changes)
+	}
+	// no global key is rewritten by every change
+	if s.state[RecentChangedKey] != nil {
+		t.Fatal(string(s.state[RecentChangedKey]))
+	}
+}
+
+func TestChangedSinceRange(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	// a change listed before the index existed
+	s.state[RecentChangedKey] = []byte(`["s0"]`)
+	snap := s.now
+	play(t, s,
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerService", "s3", "t", "d", "alice"),
+		call(ALICE, "editService", "s2", "Type", "x"),
+	)
+	changed := func() string {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(ALICE, "queryChangedSince", itoa64(snap))), &got)
+		var names []string
+		for _, c := range got {
+			names = append(names, c["name"].(string)+":"+c["change"].(string))
+		}
+		return strings.Join(names, ",")
+	}
+	// s1 changed before the snapshot, s0 has no history
+	if c := changed(); c != "s2:created,s3:created" {
+		t.Fatal(c)
+	}
+	ok(t, s.invoke(ADMIN, "setConfig", "RECENT_SIZE", "1"))
+	if c := changed(); c != "s2:created" {
+		t.Fatal(c)
+	}
+}
+
+func TestReward(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(BOB, "rewardService", "s1", "INK", "5"),
+		fails(BOB, "rewardService", "s1", "FOO", "5"),
+	)
+}
+
+func TestFinalize(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "finalizeService", "s1"),
+		call(ALICE, "publishService", "s1"),
+		fails(BOB, "finalizeService", "s1"),
+		call(ALICE, "finalizeService", "s1"),
+		fails(ALICE, "finalizeService", "s1"),
+		fails(ALICE, "editService", "s1", "Type", "x"),
+		call(ALICE, "invalidateService", "s1"),
+	)
+}
+
+func TestDup(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "Buy  Cheap stuff", "alice"),
+		call(ALICE, "registerService", "s2", "t", "buy cheap stuff ", "alice"),
+		call(ALICE, "registerService", "s3", "t", "other", "alice"),
+		fails(ALICE, "findDuplicateDescriptions"),
+	)
+	var got struct {
+		Duplicates []struct{ Services []string }
+		Scanned    int
+	}
+	json.Unmarshal(ok(t, s.invoke(ADMIN, "findDuplicateDescriptions")), &got)
+	if got.Scanned != 3 || len(got.Duplicates) != 1 || strings.Join(got.Duplicates[0].Services, ",") != "s1,s2" {
+		t.Fatalf("%+v", got)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	var u map[string]interface{}
+	json.Unmarshal(s.state[UserPrefix+"alice"], &u)
+	u["contribution"] = 1000
+	s.state[UserPrefix+"alice"], _ = json.Marshal(u)
+	play(t, s,
+		fails(ADMIN, "decayContributions"),
+		call(ADMIN, "setConfig", "DECAY_PERCENT", "10"),
+		call(ADMIN, "setConfig", "DECAY_PERIOD", "1"),
+	)
+	s.now += 2*86400 + 100
+	play(t, s,
+		call(ADMIN, "decayContributions"),
+		call(ADMIN, "decayContributions"),
+	)
+	if u := getUser(t, s, "alice"); u["contribution"].(float64) != 810 || u["decayedPeriods"].(float64) != 2 {
+		t.Fatal(u)
+	}
+}
+
+func TestDecayRecompute(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "createMashup", "m1", "t", "d", "s1"),
+		call(ADMIN, "setConfig", "DECAY_PERCENT", "50"),
+		call(ADMIN, "setConfig", "DECAY_PERIOD", "1"),
+	)
+	s.now += 2*86400 + 100
+	ok(t, s.invoke(ADMIN, "decayContributions"))
+	if u := getUser(t, s, "alice"); u["contribution"].(float64) != 3 {
+		t.Fatal(u)
+	}
+	// recomputed to 20 on m2, then decayed for the same two periods
+	play(t, s,
+		call(BOB, "createMashup", "m2", "t", "d", "s1"),
+		call(ADMIN, "decayContributions"),
+		call(BOB, "recalculateContribution", "alice"),
+	)
+	if u := getUser(t, s, "alice"); u["contribution"].(float64) != 5 || u["decayedPeriods"].(float64) != 2 {
+		t.Fatal(u)
+	}
+	// an action restarts the decay from the recomputed value
+	ok(t, s.invoke(ALICE, "registerService", "s2", "t", "d", "alice"))
+	if u := getUser(t, s, "alice"); u["contribution"].(float64) != 20 || u["decayedPeriods"].(float64) != 0 {
+		t.Fatal(u)
+	}
+}
+
+func TestTreasury(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ADMIN, "setConfig", "MASHUP_FEE", "100"),
+		call(ADMIN, "setConfig", "TREASURY", ADMIN),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+		fails(BOB, "grantFromTreasury", ALICE, "INK", "30", "grant"),
+		call(ADMIN, "grantFromTreasury", ALICE, "INK", "30", "grant"),
+	)
+	var entries []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryTreasuryLog")), &entries)
+	if len(entries) != 2 || entries[0]["direction"] != "in" || entries[0]["amount"] != "100" ||
+		entries[1]["direction"] != "out" || entries[1]["amount"] != "30" {
+		t.Fatal(entries)
+	}
+	if b := string(ok(t, s.invoke(BOB, "queryTreasuryBalance"))); b != `{"INK":"70"}` {
+		t.Fatal(b)
+	}
+	// entries are keyed per transaction, no shared counter is written
+	for k := range s.state {
+		if strings.HasPrefix(k, "SEQ_") {
+			t.Fatal(k)
+		}
+	}
+	if entries[0]["txId"] == "" || entries[0]["txId"] == entries[1]["txId"] {
+		t.Fatal(entries)
+	}
+	// entries recorded with a sequence number still read first
+	s.state[TreasuryPrefix+"0000000001"] = []byte(`{"seq":1,"direction":"in","amount":"5","token":"INK"}`)
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryTreasuryLog")), &entries)
+	if len(entries) != 3 || entries[0]["seq"].(float64) != 1 {
+		t.Fatal(entries)
+	}
+	if b := string(ok(t, s.invoke(BOB, "queryTreasuryBalance"))); b != `{"INK":"75"}` {
+		t.Fatal(b)
+	}
+
+	// the treasury is compared in the same normalized form it is stored in
+	ok(t, s.invoke(ADMIN, "setProtocolFee", "0", "i"+ADMIN))
+	if string(s.state[ConfigPrefix+ConfigTreasury]) != ADMIN {
+		t.Fatal(string(s.state[ConfigPrefix+ConfigTreasury]))
+	}
+	s.issueToken("i"+ADMIN, "INK", 1)
+	ok(t, s.invoke("i"+ADMIN, "grantFromTreasury", ALICE, "INK", "1", "grant"))
+}
+
+func TestDigest(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	a := string(ok(t, s.invoke(BOB, "registryDigest", "services")))
+	b := string(ok(t, s.invoke(BOB, "registryDigest", "services")))
+	ok(t, s.invoke(ALICE, "editService", "s1", "Type", "x"))
+	c := string(ok(t, s.invoke(BOB, "registryDigest", "services")))
+	if a != b || a == c {
+		t.Fatal(a, b, c)
+	}
+	if u := string(ok(t, s.invoke(BOB, "registryDigest", "users"))); !strings.Contains(u, `"count":1`) {
+		t.Fatal(u)
+	}
+	bad(t, s.invoke(BOB, "registryDigest", "x"))
+
+	// a partial digest would compare wrongly
+	play(t, s,
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ADMIN, "setConfig", "SCAN_LIMIT", "1"),
+		fails(BOB, "registryDigest", "services"),
+		call(BOB, "registryDigest", "users"),
+	)
+}
+
+func TestNoRange(t *testing.T) {
+	s := newStub(t)
+	s.noRange = true
+	play(t, s,
+		fails(BOB, "queryServiceByRange", "", ""),
+		fails(BOB, "queryTreasuryLog"),
+	)
+}
+
+func TestQuota(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ADMIN, "setConfig", "SERVICE_QUOTA", "1"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "invalidateService", "s1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+	)
+}
+
+func TestQuotaMashups(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ADMIN, "setConfig", "SERVICE_QUOTA", "2"),
+		// services and mashups count together
+		call(ALICE, "createMashup", "m1", "t", "d", "s1"),
+		fails(ALICE, "registerService", "s2", "t", "d", "alice"),
+		fails(ALICE, "createMashup", "m2", "t", "d", "s1"),
+		// a creator without a user is counted by address
+		call(BOB, "createMashup", "b1", "t", "d", "s1"),
+		call(BOB, "createMashup", "b2", "t", "d", "s1"),
+		fails(BOB, "createMashup", "b3", "t", "d", "s1"),
+		// and keeps the mashups once registered
+		call(BOB, "registerUser", "bob", "hi"),
+		fails(BOB, "registerService", "s3", "t", "d", "bob"),
+		call(ALICE, "removeService", "m1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+	)
+	for _, k := range []string{"\x00developer~service\x00alice\x00s1\x00", "\x00developer~service\x00" + BOB + "\x00b1\x00"} {
+		if s.state[k] == nil {
+			t.Fatalf("%q not indexed", k)
+		}
+	}
+}
+
+func TestRegisterServiceNoUser(t *testing.T) {
+	s := newStub(t)
+	bad(t, s.invoke(ALICE, "registerService", "s1", "t", "d", "ghost"))
+}
+
+func TestCapabilities(t *testing.T) {
+	s := newStub(t)
+	quota := func() bool {
+		c := map[string]bool{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "capabilities")), &c)
+		return c["serviceQuota"]
+	}
+	if quota() {
+		t.Fatal("quota reported before it is configured")
+	}
+	ok(t, s.invoke(ADMIN, "setConfig", "SERVICE_QUOTA", "3"))
+	if !quota() {
+		t.Fatal("quota not reported")
+	}
+}
+
+func TestExternalComposition(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "registerService", "b1", "t", "d", "bob"),
+		call(BOB, "publishService", "b1"),
+		call(ALICE, "createMashup", "m0", "t", "d", "s1"),
+		call(ADMIN, "setConfig", "REQUIRE_EXTERNAL_COMPOSITION", "true"),
+		fails(ADMIN, "setConfig", "REQUIRE_EXTERNAL_COMPOSITION", "yes"),
+		fails(ALICE, "createMashup", "m1", "t", "d", "s1"),
+		call(ALICE, "createMashup", "m2", "t", "d", "s1", "b1"),
+	)
+}
+
+func TestResponseSize(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ADMIN, "setConfig", "MAX_RESPONSE_SIZE", "50"),
+		fails(ALICE, "queryService", "s1"),
+		call(ADMIN, "setConfig", "MAX_RESPONSE_SIZE", "0"),
+		call(ALICE, "queryService", "s1"),
+	)
+}
+
+func TestUpdateUser(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		fails(BOB, "updateUser", "alice", "x"),
+		fails(BOB, "updateUser", "ghost", "x"),
+		call(ALICE, "updateUser", "alice", "hello"),
+	)
+	if u := getUser(t, s, "alice"); u["introduction"] != "hello" {
+		t.Fatal(u)
+	}
+}
+
+func TestVerifyOwnership(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, tc := range []struct {
+		sender string
+		want   string
+	}{
+		{ALICE, `{"owner":true}`},
+		{BOB, `{"owner":false}`},
+	} {
+		if got := string(ok(t, s.invoke(tc.sender, "verifyOwnership", "alice"))); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.sender, got, tc.want)
+		}
+	}
+	bad(t, s.invoke(BOB, "verifyOwnership", "ghost"))
+}
+
+func TestRemoveUserBlocked(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerService", "s3", "t", "d", "alice"),
+		call(ALICE, "publishService", "s3"),
+		call(ALICE, "invalidateService", "s3"),
+		fails(BOB, "removeUser", "alice", "true"),
+		fails(ALICE, "removeUser", "alice"),
+		fails(ALICE, "removeUser", "alice", "x"),
+	)
+	// the draft can't be invalidated on the way
+	msg := bad(t, s.invoke(ALICE, "removeUser", "alice", "true"))
+	if !strings.Contains(msg, "s2") || getSvc(t, s, "s1")["status"] != "available" {
+		t.Fatal(msg)
+	}
+	play(t, s,
+		call(ALICE, "removeService", "s2"),
+		call(ALICE, "removeUser", "alice", "true"),
+	)
+	if st := getSvc(t, s, "s1")["status"]; st != "invalid" {
+		t.Fatal(st)
+	}
+	if getUser(t, s, "alice") != nil {
+		t.Fatal("user not removed")
+	}
+}
+
+func TestRemoveUserByAdmin(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		fails(BOB, "removeUser", "alice"),
+		call(ADMIN, "removeUser", "alice"),
+	)
+}
+
+func TestServiceHistory(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getServiceHistory", "s1")), &got)
+	if len(got) != 2 || got[0]["Value"].(map[string]interface{})["status"] != "created" ||
+		got[1]["Value"].(map[string]interface{})["status"] != "available" {
+		t.Fatal(got)
+	}
+	if h := string(ok(t, s.invoke(BOB, "getServiceHistory", "none"))); h != "[]" {
+		t.Fatal(h)
+	}
+}
+
+func TestMashupIncentive(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		fails(BOB, "setMashupIncentive", "25"),
+		fails(ADMIN, "setMashupIncentive", "x"),
+		call(ADMIN, "setMashupIncentive", "25"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+	if s.balances[BOB]["INK"].Int64() != 975 {
+		t.Fatal(s.balances)
+	}
+	delete(s.state, ConfigPrefix+ConfigIncentive)
+	ok(t, s.invoke(BOB, "createMashup", "m2", "t", "d", "s1"))
+	if s.balances[BOB]["INK"].Int64() != 965 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestMashupCycle(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "createMashup", "m1", "t", "d", "s1"),
+	)
+	// m2 composites m1; m1 is removed and then recreated from m2
+	s.state[ServicePrefix+"m2"] = []byte(`{"name":"m2","developer":"alice","isMashup":true,"composition":{"m1":1}}`)
+	delete(s.state, ServicePrefix+"m1")
+	bad(t, s.invoke(ALICE, "createMashup", "m1", "t", "d", "m2"))
+}
+
+func TestMashupCycleShared(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+	)
+	mashup := func(name string, components ...string) {
+		composition := map[string]int{}
+		for _, c := range components {
+			composition[c] = 1
+		}
+		b, _ := json.Marshal(map[string]interface{}{"name": name, "developer": ALICE, "isMashup": true,
+			"status": "available", "composition": composition})
+		s.state[ServicePrefix+name] = b
+	}
+	// 14 levels of two mashups, each compositing both of the level below
+	mashup("a0", "s1")
+	mashup("b0", "s1")
+	for i := 1; i < 14; i++ {
+		mashup("a"+strconv.Itoa(i), "a"+strconv.Itoa(i-1), "b"+strconv.Itoa(i-1))
+		mashup("b"+strconv.Itoa(i), "a"+strconv.Itoa(i-1), "b"+strconv.Itoa(i-1))
+	}
+	s.reads = 0
+	ok(t, s.invoke(ALICE, "createMashup", "top", "t", "d", "a13", "b13"))
+	if s.reads > 500 {
+		t.Fatal("shared compositions walked again:", s.reads)
+	}
+
+	// x passes at depth 1 but is too deep at the end of a chain
+	mashup("y", "s1")
+	mashup("x", "y")
+	mashup("c15", "x")
+	for i := 14; i > 0; i-- {
+		mashup("c"+strconv.Itoa(i), "c"+strconv.Itoa(i+1))
+	}
+	msg := bad(t, s.invoke(ALICE, "createMashup", "deep", "t", "d", "x", "c1"))
+	if !strings.Contains(msg, "too deep") {
+		t.Fatal(msg)
+	}
+}
+
+func TestMashupOwnerByAddress(t *testing.T) {
+	s := newStub(t)
+	CAROL := "dddddddddddddddddddddddddddddddddddddddd"
+	s.issueToken(CAROL, "INK", 1000)
+	play(t, s,
+		fails(BOB, "registerUser", ALICE, "squatter"),
+		fails(BOB, "registerUser", "i"+ALICE, "squatter"),
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "createMashup", "m", "t", "d", "s1"),
+		call(ALICE, "publishService", "m"),
+	)
+	// a user named after the creator's address, left from before names were checked
+	s.state[UserPrefix+ALICE] = []byte(`{"name":"` + ALICE + `","address":"` + BOB + `"}`)
+	play(t, s,
+		fails(BOB, "setServicePrice", "m", "5"),
+		call(ALICE, "setServicePrice", "m", "5"),
+		call(CAROL, "createMashup", "m2", "t", "d", "m"),
+	)
+	if s.balances[ALICE]["INK"].Int64() != 1010 || s.balances[BOB]["INK"].Int64() != 1000 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestMashupDeveloperChecks(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+		fails(ALICE, "publishService", "m"),
+		call(BOB, "publishService", "m"),
+		fails(ALICE, "editService", "m", "Description", "x"),
+		call(BOB, "editService", "m", "Description", "new"),
+		call(BOB, "invalidateService", "m"),
+		fails(ALICE, "revalidateService", "m"),
+		call(BOB, "revalidateService", "m"),
+		call(ADMIN, "invalidateService", "m"),
+	)
+}
+
+func TestRemoveService(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s2"),
+		call(ALICE, "createMashup", "m1", "t", "d", "s1"),
+		fails(BOB, "removeService", "s2"),
+		fails(ALICE, "removeService", "s1"),
+		call(ALICE, "removeService", "s2"),
+		call(ALICE, "removeService", "m1"),
+		call(ALICE, "removeService", "s1"),
+	)
+}
+
+func TestRemoveServicePurges(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s1", "s1_x", "s2"} {
+		play(t, s,
+			call(ALICE, "registerService", name, "t", "d", "alice"),
+			call(ALICE, "editService", name, "Description", "d2"),
+			call(ALICE, "publishService", name),
+			call(BOB, "voteService", name, "up"),
+			call(BOB, "commentService", name, "nice"),
+		)
+	}
+	play(t, s,
+		call(BOB, "createMashup", "m1", "t", "d", "s1", "s2"),
+		call(BOB, "removeService", "m1"),
+		call(ALICE, "removeService", "s1"),
+	)
+	vote_prefix, _ := s.CreateCompositeKey(VoteIndex, []string{"s1"})
+	kept_vote, _ := s.CreateCompositeKey(VoteIndex, []string{"s1_x", BOB})
+	if s.state[kept_vote] == nil {
+		t.Fatal("s1_x lost its vote")
+	}
+	keep := 0
+	for k := range s.state {
+		if strings.HasPrefix(k, vote_prefix) {
+			t.Fatal("left behind:", k)
+		}
+		for _, prefix := range []string{"COMMENT_s1_", "SERVER_VERSION_s1_"} {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if !strings.HasPrefix(k, prefix+"x_") {
+				t.Fatal("left behind:", k)
+			}
+			keep++
+		}
+	}
+	if keep != 2 {
+		t.Fatal("s1_x lost its data:", keep)
+	}
+	if c := getSvc(t, s, "s2")["composition"].(map[string]interface{}); len(c) != 0 {
+		t.Fatal(c)
+	}
+
+	// the name starts afresh
+	play(t, s,
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "voteService", "s1", "up"),
+		fails(ALICE, "queryServiceVersion", "s1", "1"),
+	)
+}
+
+func TestEditReturnsNew(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "editService", "s1", "Description", "new")), &m)
+	if m["description"] != "new" {
+		t.Fatal(m)
+	}
+	play(t, s,
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "invalidateService", "s1"),
+		fails(ALICE, "editService", "s1", "Description", "x"),
+	)
+}
+
+func TestRenameService(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s2"),
+		call(ALICE, "createMashup", "m1", "t", "d", "s1", "s2"),
+		call(BOB, "voteService", "s1", "up"),
+		call(BOB, "commentService", "s1", "nice"),
+		fails(ALICE, "editService", "s1", "Name", "s2"),
+		call(ALICE, "editService", "s1", "Name", "s9"),
+	)
+	if s.state[ServicePrefix+"s1"] != nil {
+		t.Fatal("old key kept")
+	}
+	m1 := getSvc(t, s, "m1")
+	if c := m1["composition"].(map[string]interface{}); c["s9"] == nil || c["s1"] != nil {
+		t.Fatal(c)
+	}
+	if v := m1["composedVersions"].(map[string]interface{}); v["s9"] == nil || v["s1"] != nil {
+		t.Fatal(v)
+	}
+
+	// the votes and comments follow the service
+	play(t, s,
+		fails(BOB, "voteService", "s9", "up"),
+	)
+	var comments []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServiceComments", "s9")), &comments)
+	if len(comments) != 1 || comments[0]["service"] != "s9" {
+		t.Fatal(comments)
+	}
+
+	// and a service registered under the old name doesn't inherit them
+	play(t, s,
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "voteService", "s1", "up"),
+	)
+	comments = nil
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServiceComments", "s1")), &comments)
+	if len(comments) != 0 {
+		t.Fatal(comments)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "createMashup", "m", "t", "d", "s1"),
+		call(ALICE, "invalidateService", "s1"),
+	)
+	if _, emitted := s.events["ServiceInvalidated"]; !emitted {
+		t.Fatal(s.events)
+	}
+}
+
+func TestByStatus(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s2"),
+		fails(ALICE, "queryServicesByStatus", "x"),
+	)
+	for _, tc := range []struct {
+		status string
+		want   int
+	}{
+		{"available", 1},
+		{"created", 1},
+		{"invalid", 0},
+	} {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByStatus", tc.status)), &got)
+		if len(got) != tc.want {
+			t.Errorf("%s: got %d services, want %d", tc.status, len(got), tc.want)
+		}
+	}
+}
+
+func TestByUser(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(BOB, "registerService", "s2", "t", "d", "bob"),
+		fails(ALICE, "queryServiceByUser", "ghost"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryServiceByUser", "bob")), &got)
+	if len(got) != 1 || got[0]["name"] != "s2" {
+		t.Fatal(got)
+	}
+}
+
+func TestInvokeCount(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	s.state[ServicePrefix+"s1"] = []byte(`{"name":"s1","developer":"alice","status":"available"}`)
+	before := string(s.state[ServicePrefix+"s1"])
+	play(t, s,
+		call(BOB, "invokeService", "s1", "INK"),
+		call(BOB, "invokeService", "s1", "INK"),
+	)
+	// the invocations never rewrite the service record
+	if string(s.state[ServicePrefix+"s1"]) != before {
+		t.Fatal(string(s.state[ServicePrefix+"s1"]))
+	}
+	invokeCount := func(name string) float64 {
+		m := map[string]interface{}{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "queryService", name)), &m)
+		return m["invokeCount"].(float64)
+	}
+	if invokeCount("s1") != 2 {
+		t.Fatal(invokeCount("s1"))
+	}
+
+	// a rename keeps the count
+	play(t, s,
+		call(ALICE, "editService", "s1", "Name", "s2"),
+		call(BOB, "invokeService", "s2", "INK"),
+	)
+	if invokeCount("s2") != 3 || string(s.state[ServicePrefix+"s2"]) == before {
+		t.Fatal(getSvc(t, s, "s2"))
+	}
+	old_prefix, _ := s.CreateCompositeKey(InvokeIndex, []string{"s1"})
+	for k := range s.state {
+		if strings.HasPrefix(k, old_prefix) {
+			t.Fatal("left behind:", k)
+		}
+	}
+}
+
+func TestVote(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "voteService", "s1", "up"),
+		fails(BOB, "voteService", "s1", "sideways"),
+	)
+	var voters []string
+	for i := 0; i < 11; i++ {
+		v := fmt.Sprintf("%040d", i)
+		voters = append(voters, v)
+		s.issueToken(v, "INK", 1000)
+		ok(t, s.invoke(v, "voteService", "s1", "up"))
+	}
+	bad(t, s.invoke(voters[0], "voteService", "s1", "down"))
+	if m := getSvc(t, s, "s1"); m["up"].(float64) != 11 || m["rewardedVotes"].(float64) != 10 {
+		t.Fatal(m)
+	}
+	// the first ten up-votes each pay the developer, the eleventh is free
+	if s.balances[voters[9]]["INK"].Int64() != 890 || s.balances[voters[10]]["INK"].Int64() != 1000 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestComments(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s1_x", "t", "d", "alice"),
+		fails(BOB, "commentService", "s1", "  "),
+		call(BOB, "commentService", "s1", "nice"),
+		call(BOB, "commentService", "s1", "very nice"),
+		call(BOB, "commentService", "s1_x", "other"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServiceComments", "s1")), &got)
+	if len(got) != 2 || got[0]["content"] != "nice" || got[1]["content"] != "very nice" {
+		t.Fatal(got)
+	}
+}
+
+func TestCommentRestrictions(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+	for _, tc := range []struct {
+		name    string
+		sender  string
+		service string
+		fail    bool
+	}{
+		{"developer on own service", ALICE, "s1", true},
+		{"creator on own mashup", BOB, "m", true},
+		{"another user", BOB, "s1", false},
+	} {
+		r := s.invoke(tc.sender, "commentService", tc.service, "nice")
+		if (r.Status != shim.OK) != tc.fail {
+			t.Errorf("%s: status %d %s", tc.name, r.Status, r.Message)
+		}
+	}
+	ok(t, s.invoke(ALICE, "invalidateService", "s1"))
+	msg := bad(t, s.invoke(BOB, "commentService", "s1", "late"))
+	if !strings.Contains(msg, "invalidated") {
+		t.Fatal(msg)
+	}
+}
+
+func TestContribution(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+	if u := getUser(t, s, "alice"); u["contribution"].(float64) == 0 {
+		t.Fatal(u)
+	}
+	play(t, s,
+		call(BOB, "commentService", "s1", "nice"),
+		call(BOB, "voteService", "s1", "up"),
+	)
+	before := getUser(t, s, "alice")["contribution"].(float64)
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "recalculateContribution", "alice")), &m)
+	if m["contribution"].(float64) <= before {
+		t.Fatal(before, m)
+	}
+}
+
+func TestWritesWithoutScan(t *testing.T) {
+	s := newStub(t)
+	s.noScan = true
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerServiceBatch", "alice", `[{"name":"s3"}]`),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "publishService", "s2"),
+		call(BOB, "createMashup", "m1", "t", "d", "s1", "s2"),
+	)
+	// two published services, composited twice by m1
+	if c := getUser(t, s, "alice")["contribution"].(float64); c != 30 {
+		t.Fatal(c)
+	}
+	play(t, s,
+		call(ALICE, "editService", "s1", "Name", "s4"),
+		fails(ALICE, "removeService", "s4"),
+	)
+	if c := getSvc(t, s, "m1")["composition"].(map[string]interface{}); c["s4"] == nil || c["s1"] != nil {
+		t.Fatal(c)
+	}
+	if c := getSvc(t, s, "s2")["composition"].(map[string]interface{}); c["s4"] == nil || c["s1"] != nil {
+		t.Fatal(c)
+	}
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "recalculateContribution", "alice")), &m)
+	if m["contribution"].(float64) != 30 {
+		t.Fatal(m)
+	}
+	play(t, s,
+		fails(ALICE, "removeUser", "alice", "true"),
+		call(ALICE, "removeService", "s3"),
+		call(ALICE, "removeUser", "alice", "true"),
+	)
+	if getSvc(t, s, "s2")["status"] != S_Invalid || getSvc(t, s, "s4")["status"] != S_Invalid {
+		t.Fatal(getSvc(t, s, "s2"), getSvc(t, s, "s4"))
+	}
+}
+
+func TestTopContributors(t *testing.T) {
+	s := newStub(t)
+	s.state[UserPrefix+"a"] = []byte(`{"name":"a","contribution":5}`)
+	s.state[UserPrefix+"b"] = []byte(`{"name":"b","contribution":9}`)
+	s.state[UserPrefix+"c"] = []byte(`{"name":"c","contribution":5}`)
+	for _, tc := range []struct {
+		n    string
+		want string
+	}{
+		{"2", "b,a"},
+		{"500", "b,a,c"},
+	} {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "queryTopContributors", tc.n)), &got)
+		names := []string{}
+		for _, u := range got {
+			names = append(names, u["name"].(string))
+		}
+		if strings.Join(names, ",") != tc.want {
+			t.Errorf("top %s: got %v, want %s", tc.n, names, tc.want)
+		}
+	}
+	bad(t, s.invoke(BOB, "queryTopContributors", "0"))
+}
+
+func TestInitAccountArgs(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		fails(ADMIN, "initAccount", "TOK", "100", "x", ALICE),
+		fails(ADMIN, "initAccount", "TOK", "100", "19", ALICE),
+		fails(ADMIN, "initAccount", "TOK", "0", "8", ALICE),
+	)
+}
+
+func TestInitAccountLegacyToken(t *testing.T) {
+	s := newStub(t)
+	s.issueToken(ADMIN, "OLD", 1000)
+	// a created token recorded without its total supply
+	s.state["OLD"] = []byte(`{"tokenName":"OLD","address":"` + ALICE + `","status":"created","decimals":2}`)
+	play(t, s,
+		fails(ADMIN, "initAccount", "OLD", "100", "2", BOB),
+		call(ADMIN, "initAccount", "OLD", "100", "2", ALICE),
+	)
+	tokenJSON := map[string]interface{}{}
+	json.Unmarshal(s.state["OLD"], &tokenJSON)
+	if tokenJSON["totalSupply"].(float64) != 100 || tokenJSON["status"] != "issued" || s.balances[ALICE]["OLD"].Int64() != 100 {
+		t.Fatal(tokenJSON, s.balances)
+	}
+}
+
+func TestRewardMissing(t *testing.T) {
+	s := newStub(t)
+	bad(t, s.invoke(BOB, "rewardService", "nope", "INK", "5"))
+	s.state[ServicePrefix+"s1"] = []byte(`{"name":"s1","developer":"ghost"}`)
+	bad(t, s.invoke(BOB, "rewardService", "s1", "INK", "5"))
+}
+
+func TestAdmins(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(BOB, "invalidateService", "s1"),
+		fails(BOB, "addAdmin", BOB),
+		call(ADMIN, "addAdmin", BOB),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "invalidateService", "s1"),
+		call(BOB, "removeAdmin", ADMIN),
+		fails(BOB, "removeAdmin", BOB),
+	)
+
+	// an upgrade keeps the admins instead of reinstating its sender
+	ok(t, s.init(ADMIN))
+	play(t, s,
+		fails(ADMIN, "addAdmin", ALICE),
+		call(BOB, "addAdmin", ALICE),
+	)
+}
+
+func TestBatch(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "registerServiceBatch", "alice", `[{"name":"s1"},{"name":"s2"},{"name":"s2"}]`),
+	)
+	if s.state[ServicePrefix+"s2"] != nil {
+		t.Fatal("partial write")
+	}
+	play(t, s,
+		fails(BOB, "registerServiceBatch", "alice", `[{"name":"s3"}]`),
+		call(ALICE, "registerServiceBatch", "alice", `[{"name":"s2","type":"a"},{"name":"s3"}]`),
+	)
+	if u := getUser(t, s, "alice"); u["developerToken"].(float64) != 3 {
+		t.Fatal(u)
+	}
+	if getSvc(t, s, "s2")["type"] != "a" || getSvc(t, s, "s3")["status"] != "created" {
+		t.Fatal(getSvc(t, s, "s2"), getSvc(t, s, "s3"))
+	}
+}
+
+func TestBatchLimit(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ADMIN, "setConfig", "MAX_BATCH_SIZE", "2"),
+		fails(ADMIN, "setConfig", "MAX_BATCH_SIZE", "0"),
+		fails(ALICE, "registerServiceBatch", "alice", `[{"name":"s1"},{"name":"s2"},{"name":"s3"}]`),
+		call(ALICE, "registerServiceBatch", "alice", `[{"name":"s1"},{"name":"s2"}]`),
+	)
+	limits := map[string]int{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getLimits")), &limits)
+	if limits["batch"] != 2 {
+		t.Fatal(limits)
+	}
+}
+
+func TestRich(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		fails(BOB, "queryServicesRich", "{}"),
+		fails(BOB, "queryServicesRich", "nope"),
+		call(BOB, "queryServicesRich", `{"selector":{"status":"available"}}`),
+	)
+	s.noRich = true
+	bad(t, s.invoke(BOB, "queryServicesRich", `{"selector":{"status":"available"}}`))
+}
+
+func TestVersions(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d0", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "editService", "s1", "Description", "d1"),
+		call(ALICE, "editService", "s1", "Name", "s2"),
+		call(ALICE, "editService", "s2", "Description", "d3"),
+	)
+	for v, want := range []string{"s1 d0", "s1 d1", "s2 d1", "s2 d3"} {
+		m := map[string]interface{}{}
+		json.Unmarshal(ok(t, s.invoke(ALICE, "queryServiceVersion", "s2", strconv.Itoa(v))), &m)
+		if got := fmt.Sprint(m["name"], " ", m["description"]); got != want || m["version"].(float64) != float64(v) {
+			t.Errorf("version %d: got %s (%v), want %s", v, got, m["version"], want)
+		}
+	}
+	play(t, s,
+		fails(ALICE, "queryServiceVersion", "s2", "9"),
+		call(ALICE, "createMashup", "m", "t", "d", "s2"),
+	)
+	if v := getSvc(t, s, "m")["composedVersions"].(map[string]interface{}); v["s2"].(float64) != 3 {
+		t.Fatal(v)
+	}
+}
+
+func TestMashupNeedsAvailable(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(BOB, "createMashup", "m", "t", "d", "s1"),
+		call(ADMIN, "createMashup", "m", "t", "d", "s1"),
+	)
+}
+
+func TestStats(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "createMashup", "m", "t", "d", "s1"),
+	)
+	var got struct {
+		ByStatus map[string]int
+		Mashups  int
+		Services int
+		Users    int
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getMarketplaceStats")), &got)
+	if got.Services != 2 || got.Mashups != 1 || got.Users != 1 || got.ByStatus["available"] != 1 || got.ByStatus["created"] != 1 {
+		t.Fatalf("%+v", got)
+	}
+}
+
+func TestDuplicateKeepsToken(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	m := map[string]interface{}{}
+	json.Unmarshal(s.state[UserPrefix+"alice"], &m)
+	if m["developerToken"].(float64) != 1 {
+		t.Fatal(m)
+	}
+}
+
+func TestTags(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		fails(BOB, "setServiceTags", "s1", "a"),
+		fails(ALICE, "setServiceTags", "s1", "a,b,c,d,e,f,g,h,i,j,k"),
+		call(ALICE, "setServiceTags", "s1", " Maps, geo ,maps"),
+		call(ALICE, "queryServicesByTag", "MAPS"),
+	)
+}
+
+func TestBulkTags(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerService", "s3", "t", "d", "alice"),
+		call(BOB, "registerService", "b1", "t", "d", "bob"),
+		call(ALICE, "setServiceTags", "s2", "maps"),
+		fails(ALICE, "bulkAddTag", "a,b", "s1"),
+		call(ADMIN, "setConfig", "MAX_BATCH_SIZE", "4"),
+		fails(ALICE, "bulkAddTag", "geo", "s1", "s2", "s3", "b1", "s1"),
+	)
+	status := func(out []byte) string {
+		var got []map[string]string
+		json.Unmarshal(out, &got)
+		statuses := []string{}
+		for _, r := range got {
+			statuses = append(statuses, r["service"]+":"+r["status"])
+		}
+		return strings.Join(statuses, ",")
+	}
+	tagged := func() string {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByTag", "geo")), &got)
+		names := []string{}
+		for _, r := range got {
+			names = append(names, r["name"].(string))
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := status(ok(t, s.invoke(ALICE, "bulkAddTag", " Geo", "s1", "s2", "s3", "b1"))); got != "s1:updated,s2:updated,s3:updated,b1:skipped" {
+		t.Fatal(got)
+	}
+	if got := tagged(); got != "s1,s2,s3" {
+		t.Fatal(got)
+	}
+	if tags := getSvc(t, s, "s2")["tags"].([]interface{}); len(tags) != 2 || tags[0] != "maps" || tags[1] != "geo" {
+		t.Fatal(tags)
+	}
+	if got := status(ok(t, s.invoke(ALICE, "bulkAddTag", "geo", "s1", "s1", "x"))); got != "s1:unchanged,s1:skipped,x:skipped" {
+		t.Fatal(got)
+	}
+
+	if got := status(ok(t, s.invoke(ALICE, "bulkRemoveTag", "geo", "s1", "s3", "b1"))); got != "s1:updated,s3:updated,b1:skipped" {
+		t.Fatal(got)
+	}
+	if got := tagged(); got != "s2" {
+		t.Fatal(got)
+	}
+	if _, has := getSvc(t, s, "s1")["tags"]; has {
+		t.Fatal("tags left on s1")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		fails("zz", "registerUser", "x", "hi"),
+		fails(ADMIN, "addAdmin", "nothex"),
+		call("i"+BOB, "registerUser", "bob", "hi"),
+	)
+	// the index is written and read under the same normalized address
+	for _, address := range []string{BOB, "i" + BOB} {
+		m := map[string]interface{}{}
+		json.Unmarshal(ok(t, s.invoke(ALICE, "queryUserByAddress", address)), &m)
+		if m["name"] != "bob" {
+			t.Fatalf("%s: %v", address, m)
+		}
+	}
+	if string(s.state[AddressPrefix+BOB]) != "bob" {
+		t.Fatal("address indexed with its prefix")
+	}
+}
+
+func TestNormalizedNames(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", " Alice ", "hi"),
+		call(ALICE, "registerService", "MyService", "t", "d", "ALICE"),
+		call(BOB, "queryService", "myservice "),
+		fails(ALICE, "registerService", "myservice", "t", "d", "alice"),
+		fails(ALICE, "registerService", "  ", "t", "d", "alice"),
+	)
+	m := getSvc(t, s, "myservice")
+	if m["displayName"] != "MyService" {
+		t.Fatalf("display name %v", m["displayName"])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		fails(BOB, "registerUser", "bob\x07", "hi"),
+		fails(ALICE, "registerService", strings.Repeat("a", 65), "t", "d", "alice"),
+		fails(ALICE, "registerService", "s1", "t", strings.Repeat("d", 2049), "alice"),
+		call(ADMIN, "setConfig", "MAX_DESCRIPTION_LENGTH", "3"),
+		fails(ALICE, "registerService", "s1", "t", "dddd", "alice"),
+		call(ALICE, "registerService", "s1", "t", "ddd", "alice"),
+		fails(ALICE, "editService", "s1", "Description", "dddd"),
+	)
+	m := map[string]int{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getLimits")), &m)
+	if m["name"] != 64 || m["description"] != 3 || m["introduction"] != 2048 {
+		t.Fatalf("limits %v", m)
+	}
+}
+
+func TestServicesByAddress(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByAddress", "i"+ALICE)), &got)
+	if len(got) != 1 {
+		t.Fatalf("services %v", got)
+	}
+	bad(t, s.invoke(BOB, "queryServicesByAddress", BOB))
+	// users registered before the index are found by scanning
+	delete(s.state, "ADDR_"+ALICE)
+	ok(t, s.invoke(BOB, "queryServicesByAddress", ALICE))
+}
+
+func TestUserByAddress(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryUserByAddress", ALICE)), &m)
+	if m["name"] != "alice" {
+		t.Fatalf("user %v", m)
+	}
+	ok(t, s.invoke(ALICE, "removeUser", "alice"))
+	if s.state["ADDR_"+ALICE] != nil {
+		t.Fatal("stale address index")
+	}
+	bad(t, s.invoke(BOB, "queryUserByAddress", ALICE))
+}
+
+func TestRewardDecimals(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	s.state["MYT"] = []byte(`{"tokenName":"MYT","decimals":2,"status":"Delivered"}`)
+	s.issueToken(BOB, "MYT", 1000)
+	ok(t, s.invoke(BOB, "rewardService", "s1", "MYT", "1.5"))
+	if s.balances[ALICE]["MYT"].Int64() != 150 {
+		t.Fatalf("balance %v", s.balances[ALICE]["MYT"])
+	}
+	ok(t, s.invoke(BOB, "rewardService", "s1", "MYT", "5", "true"))
+	if s.balances[ALICE]["MYT"].Int64() != 155 {
+		t.Fatalf("balance %v", s.balances[ALICE]["MYT"])
+	}
+	play(t, s,
+		fails(BOB, "rewardService", "s1", "MYT", "1.555"),
+		fails(BOB, "rewardService", "s1", "MYT", "-1"),
+	)
+}
+
+func TestQueryBalance(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	m := map[string]string{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryBalance", "alice")), &m)
+	if m["INK"] != "1000" {
+		t.Fatalf("balances %v", m)
+	}
+	bad(t, s.invoke(BOB, "queryBalance", "nobody"))
+}
+
+func TestIncentiveToken(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		fails(ADMIN, "setIncentiveToken", "MYT"),
+	)
+	s.state["MYT"] = []byte(`{"tokenName":"MYT","decimals":0,"status":"issued"}`)
+	play(t, s,
+		fails(BOB, "setIncentiveToken", "MYT"),
+		call(ADMIN, "setIncentiveToken", "MYT"),
+		fails(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+	s.issueToken(BOB, "MYT", 50)
+	ok(t, s.invoke(BOB, "createMashup", "m", "t", "d", "s1"))
+	if s.balances[ALICE]["MYT"].Int64() != 10 || s.balances[BOB]["INK"].Int64() != 1000 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "deprecateService", "s1"),
+		call(ALICE, "publishService", "s1"),
+		fails(BOB, "deprecateService", "s1"),
+		call(ALICE, "deprecateService", "s1"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByStatus", "deprecated")), &got)
+	if len(got) != 1 {
+		t.Fatalf("deprecated %v", got)
+	}
+	play(t, s,
+		fails(BOB, "createMashup", "m", "t", "d", "s1"),
+		call(ADMIN, "setConfig", "ALLOW_DEPRECATED_COMPOSITION", "true"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+	)
+}
+
+func TestStatusTransitions(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "invalidateService", "s1"),
+		fails(ALICE, "deprecateService", "s1"),
+		call(ALICE, "publishService", "s1"),
+		fails(ALICE, "publishService", "s1"),
+		call(ALICE, "deprecateService", "s1"),
+		fails(ALICE, "deprecateService", "s1"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "invalidateService", "s1"),
+		fails(ALICE, "publishService", "s1"),
+		fails(ALICE, "invalidateService", "s1"),
+		fails(ALICE, "deprecateService", "s1"),
+	)
+}
+
+func TestMashupsUsingService(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+	)
+	if string(ok(t, s.invoke(BOB, "queryMashupsUsingService", "s1"))) != "[]" {
+		t.Fatal("expected no mashups")
+	}
+	ok(t, s.invoke(BOB, "createMashup", "m", "t", "d", "s1"))
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryMashupsUsingService", "s1")), &got)
+	if len(got) != 1 || got[0]["name"] != "m" {
+		t.Fatalf("mashups %v", got)
+	}
+}
+
+func TestMashupContributors(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "registerService", "s2", "t", "d", "bob"),
+		call(BOB, "publishService", "s2"),
+		call(ALICE, "createMashup", "m", "t", "d", "s1", "s2"),
+	)
+	m := getSvc(t, s, "m")
+	if m["contributorCount"].(float64) != 1 {
+		t.Fatal(m)
+	}
+	if s.balances[ALICE]["INK"].Int64() != 990 || s.balances[BOB]["INK"].Int64() != 1010 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestMashupSelfComposition(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "registerService", "s2", "t", "d", "bob"),
+		call(BOB, "publishService", "s2"),
+	)
+	alice, bob := getUser(t, s, "alice")["developerToken"], getUser(t, s, "bob")["developerToken"]
+	ok(t, s.invoke(ALICE, "createMashup", "m", "t", "d", "s1", "s2"))
+	// the creator composing their own service pays and rewards only bob
+	if getUser(t, s, "alice")["developerToken"] != alice || getUser(t, s, "bob")["developerToken"] == bob {
+		t.Fatal(getUser(t, s, "alice"), getUser(t, s, "bob"))
+	}
+	if s.balances[ALICE]["INK"].Int64() != 990 || s.balances[BOB]["INK"].Int64() != 1010 {
+		t.Fatal(s.balances)
+	}
+}
+
+func TestSimulateMashup(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(BOB, "simulateMashup", "m", "t", "d", "s1"),
+		call(ALICE, "publishService", "s1"),
+	)
+	before := len(s.state)
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "simulateMashup", "m", "t", "d", "s1")), &m)
+	if len(s.state) != before || s.balances[BOB]["INK"].Int64() != 1000 {
+		t.Fatal("simulateMashup changed the state")
+	}
+	if m["sufficient"] != true || m["totals"].(map[string]interface{})["INK"] != "10" {
+		t.Fatal(m)
+	}
+}
+
+func TestInvokePrice(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		fails(ALICE, "registerService", "s1", "t", "d", "alice", "-1"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice", "30"),
+		call(BOB, "invokeService", "s1", "INK"),
+	)
+	if s.balances[ALICE]["INK"].Int64() != 1030 || s.balances[BOB]["INK"].Int64() != 970 {
+		t.Fatal(s.balances)
+	}
+	play(t, s,
+		call(ALICE, "invokeService", "s1", "INK"),
+		call(ALICE, "setServicePrice", "s1", "5000"),
+		fails(BOB, "invokeService", "s1", "INK"),
+	)
+}
+
+func TestServicePrice(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryService", "s1")), &m)
+	if m["price"] != "0" || m["priceToken"] != "INK" {
+		t.Fatal(m)
+	}
+	play(t, s,
+		fails(BOB, "setServicePrice", "s1", "5"),
+		fails(ALICE, "setServicePrice", "s1", "5", "MYT"),
+	)
+	s.state["MYT"] = []byte(`{"tokenName":"MYT","decimals":0,"status":"issued"}`)
+	s.issueToken(BOB, "MYT", 10)
+	play(t, s,
+		call(ALICE, "setServicePrice", "s1", "5", "MYT"),
+		call(BOB, "invokeService", "s1", "INK"),
+	)
+	if s.balances[ALICE]["MYT"].Int64() != 5 {
+		t.Fatal(s.balances)
+	}
+	// older records without a price are free
+	s.state[ServicePrefix+"old"] = []byte(`{"name":"old","developer":"alice"}`)
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryService", "old")), &m)
+	if m["price"] != "0" || m["priceToken"] != "INK" {
+		t.Fatal(m)
+	}
+	ok(t, s.invoke(BOB, "invokeService", "old", "INK"))
+}
+
+func TestServicePriceFinalized(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice", "5"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "finalizeService", "s1"),
+	)
+	msg := bad(t, s.invoke(ALICE, "setServicePrice", "s1", "50"))
+	if !strings.Contains(msg, "finalized") || getSvc(t, s, "s1")["price"] != "5" {
+		t.Fatal(msg, getSvc(t, s, "s1"))
+	}
+}
+
+func TestServicesByType(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "api/rest", "d", "alice"),
+		call(ALICE, "registerService", "s2", "api", "d", "alice"),
+		call(ALICE, "registerService", "s3", "data", "d", "alice"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByType", "api")), &got)
+	if len(got) != 1 {
+		t.Fatal(got)
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesByType", "api", "prefix")), &got)
+	if len(got) != 2 || got[1]["Number"] != "2" {
+		t.Fatal(got)
+	}
+	bad(t, s.invoke(BOB, "queryServicesByType", "api", "fuzzy"))
+}
+
+func TestTxTime(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	s.now = 1700000000 - 1
+	ok(t, s.invoke(ALICE, "registerService", "s1", "t", "d", "alice"))
+	if m := getSvc(t, s, "s1"); m["createdTime"] != "2023-11-14T22:13:20Z" {
+		t.Fatal(m["createdTime"])
+	}
+	// older UnixDate records still parse
+	s.state[ServicePrefix+"old"] = []byte(`{"name":"old","developer":"alice","status":"created","createdTime":"Mon Jan  1 00:00:00 UTC 2001"}`)
+	ok(t, s.invoke(ADMIN, "sweepStaleDrafts"))
+	if s.state[ServicePrefix+"old"] != nil {
+		t.Fatal("stale UnixDate draft not swept")
+	}
+}
+
+func TestRecentServices(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s1", "s2", "s3"} {
+		ok(t, s.invoke(ALICE, "registerService", name, "t", "d", "alice"))
+	}
+	play(t, s,
+		call(ALICE, "editService", "s2", "Name", "s4"),
+		call(ALICE, "removeService", "s3"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryRecentServices", "5")), &got)
+	if len(got) != 2 || got[0]["name"] != "s4" || got[1]["name"] != "s1" {
+		t.Fatal(got)
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryRecentServices", "1")), &got)
+	if len(got) != 1 || got[0]["name"] != "s4" {
+		t.Fatal(got)
+	}
+	bad(t, s.invoke(BOB, "queryRecentServices", "0"))
+
+	// the scan stops after the requested services, short of the oldest entries
+	oldest, _ := s.CreateCompositeKey(ServiceTimeIndex, []string{"9999999999999999999"})
+	s.state[oldest] = []byte{0x00}
+	ok(t, s.invoke(BOB, "queryRecentServices", "2"))
+	bad(t, s.invoke(BOB, "queryRecentServices", "3"))
+}
+
+func TestBanUser(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		fails(BOB, "banUser", "alice"),
+		call(ADMIN, "banUser", "alice"),
+		fails(ADMIN, "banUser", "alice"),
+	)
+	m := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryUser", "alice")), &m)
+	if m["banned"] != true {
+		t.Fatal(m)
+	}
+	play(t, s,
+		fails(ALICE, "registerService", "s2", "t", "d", "alice"),
+		fails(ALICE, "createMashup", "m", "t", "d", "s1"),
+		fails(ALICE, "commentService", "s1", "x"),
+		call(BOB, "queryService", "s1"),
+		call(ADMIN, "unbanUser", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+	)
+}
+
+func TestTooFewArgs(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ADMIN, "givesToken", "INK", "alice"),
+		fails(BOB, "invokeService"),
+		fails(BOB, "invokeService", "nope"),
+		call(BOB, "invokeService", "s1"),
+	)
+}
+
+func TestCompositionGraph(t *testing.T) {
+	s := newStub(t)
+	s.state[ServicePrefix+"a"] = []byte(`{"name":"a","isMashup":true,"composition":{"b":1,"c":1,"gone":1}}`)
+	s.state[ServicePrefix+"b"] = []byte(`{"name":"b","isMashup":true,"composition":{"a":1,"c":1}}`)
+	s.state[ServicePrefix+"c"] = []byte(`{"name":"c"}`)
+	var g struct {
+		Nodes []map[string]interface{}
+		Edges []map[string]string
+		Cycle bool
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getCompositionGraph", "a")), &g)
+	if len(g.Nodes) != 4 || len(g.Edges) != 5 || !g.Cycle {
+		t.Fatalf("%+v", g)
+	}
+	bad(t, s.invoke(BOB, "getCompositionGraph", "c"))
+}
+
+func TestCoOccurrence(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s1", "s2", "s3"} {
+		ok(t, s.invoke(ALICE, "registerService", name, "t", "d", "alice"))
+		ok(t, s.invoke(ALICE, "publishService", name))
+	}
+	play(t, s,
+		call(BOB, "createMashup", "m1", "t", "d", "s1", "s2", "s3"),
+		call(BOB, "createMashup", "m2", "t", "d", "s1", "s3"),
+		fails(BOB, "queryCoOccurrence", "s1"),
+	)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryCoOccurrence", "s1")), &got)
+	if len(got) != 2 || got[0]["service"] != "s3" || got[0]["count"].(float64) != 2 {
+		t.Fatal(got)
+	}
+	ok(t, s.invoke(ALICE, "editService", "s3", "Name", "s4"))
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryCoOccurrence", "s1")), &got)
+	if got[0]["service"] != "s4" {
+		t.Fatal(got)
+	}
+}
+
+func TestServicePaid(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s1", "s2"} {
+		ok(t, s.invoke(ALICE, "registerService", name, "t", "d", "alice"))
+		ok(t, s.invoke(ALICE, "publishService", name))
+	}
+	play(t, s,
+		call(BOB, "createMashup", "m1", "t", "d", "s1", "s2"),
+		fails(BOB, "queryServicePaid", "s1"),
+		call(ALICE, "editService", "s2", "DataShareable", "true"),
+		fails(BOB, "queryCoOccurrence", "s2"),
+		fails(BOB, "queryServicePaid", "m1"),
+	)
+	ink := func(addr string) int64 { return s.balances[addr]["INK"].Int64() }
+	alice, bob := ink(ALICE), ink(BOB)
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicePaid", "s2")), &got)
+	if len(got) != 1 || got[0]["service"] != "s1" {
+		t.Fatal(got)
+	}
+	if ink(ALICE) != alice+10 || ink(BOB) != bob-10 {
+		t.Fatal(ink(ALICE), ink(BOB))
+	}
+	ok(t, s.invoke(ALICE, "queryServicePaid", "s2"))
+	ok(t, s.invoke("i"+ALICE, "queryServicePaid", "s2"))
+	if ink(ALICE) != alice+10 {
+		t.Fatal(ink(ALICE))
+	}
+	s.state[ConfigPrefix+ConfigDataPrice] = []byte("ten")
+	bad(t, s.invoke(BOB, "queryServicePaid", "s2"))
+	delete(s.state, ConfigPrefix+ConfigDataPrice)
+
+	// not shareable fails even when the data is free
+	play(t, s,
+		call(ADMIN, "setConfig", "DATA_PRICE", "0"),
+		fails(BOB, "queryServicePaid", "s1"),
+		call(ALICE, "editService", "s2", "DataShareable", "false"),
+		fails(BOB, "queryServicePaid", "s2"),
+		fails(ALICE, "queryServicePaid", "s1"),
+	)
+}
+
+func TestAccessLog(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "editService", "s1", "DataShareable", "true"),
+		call(BOB, "queryServicePaid", "s1"),
+		call(BOB, "queryServicePaid", "s1"),
+		call(ALICE, "queryServicePaid", "s1"),
+		fails(BOB, "queryAccessLog", "s1"),
+	)
+	var log []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryAccessLog", "s1")), &log)
+	if len(log) != 3 || log[0]["payer"] != BOB || log[0]["amount"] != "10" || log[2]["payer"] != ALICE || log[2]["amount"] != "0" ||
+		log[0]["txId"] == log[1]["txId"] || log[0]["time"] == "" {
+		t.Fatal(log)
+	}
+
+	// the log follows a rename and goes with the service
+	ok(t, s.invoke(ALICE, "editService", "s1", "Name", "s2"))
+	json.Unmarshal(ok(t, s.invoke(ALICE, "queryAccessLog", "s2")), &log)
+	if len(log) != 3 || log[0]["service"] != "s2" {
+		t.Fatal(log)
+	}
+	ok(t, s.invoke(ALICE, "removeService", "s2"))
+	for k := range s.state {
+		if strings.HasPrefix(k, "ACCESSLOG_") {
+			t.Fatal("left behind:", k)
+		}
+	}
+}
+
+func TestUserPortfolio(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		fails(ALICE, "getUserPortfolio", "carol"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice", "30"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "invokeService", "s1", "INK"),
+		call(BOB, "createMashup", "m", "t", "d", "s1"),
+		call(BOB, "invokeService", "s1", "INK"),
+	)
+	p := map[string]interface{}{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getUserPortfolio", "alice")), &p)
+	if p["earned"].(map[string]interface{})["INK"] != "70" || len(p["services"].([]interface{})) != 1 || len(p["mashups"].([]interface{})) != 0 {
+		t.Fatal(p)
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getUserPortfolio", "bob")), &p)
+	if len(p["mashups"].([]interface{})) != 1 || len(p["earned"].(map[string]interface{})) != 0 {
+		t.Fatal(p)
+	}
+}
+
+func TestProtocolFee(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice", "100"),
+		fails(ADMIN, "setProtocolFee", "10001"),
+		fails(ALICE, "setProtocolFee", "250", TREASURY),
+		call(ADMIN, "setProtocolFee", "250"),
+		fails(BOB, "invokeService", "s1", "INK"),
+		call(ADMIN, "setProtocolFee", "250", TREASURY),
+		call(BOB, "invokeService", "s1", "INK"),
+		call(BOB, "invokeService", "s1", "INK"),
+	)
+	if s.balances[TREASURY]["INK"].Int64() != 4 || s.balances[ALICE]["INK"].Int64() != 1196 || s.balances[BOB]["INK"].Int64() != 800 {
+		t.Fatal(s.balances)
+	}
+	if string(s.state["STATS_REVENUE"]) != `{"INK":"4"}` {
+		t.Fatal(string(s.state["STATS_REVENUE"]))
+	}
+}
+
+func TestServiceByRangeSort(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "zz", "t", "d", "alice"),
+		call(ALICE, "registerService", "aa", "t", "d", "alice"),
+		call(ALICE, "editService", "zz", "Description", "d2"),
+		fails(BOB, "queryServiceByRange", "", "", "size"),
+	)
+	order := func(sortBy string) []string {
+		var got []map[string]interface{}
+		out := ok(t, s.invoke(BOB, "queryServiceByRange", "", "", sortBy))
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatal(err, string(out))
+		}
+		names := []string{}
+		for _, r := range got {
+			rec, _ := r["Record"].(map[string]interface{})
+			if _, isService := rec["createdTime"]; isService {
+				names = append(names, rec["name"].(string))
+			}
+		}
+		return names
+	}
+	if n := order("name"); n[0] != "aa" {
+		t.Fatal(n)
+	}
+	if n := order("created"); n[0] != "zz" {
+		t.Fatal(n)
+	}
+	if n := order("updated"); n[0] != "aa" {
+		t.Fatal(n)
+	}
+}
+
+func TestServiceByRangeTrust(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s0", "s1", "s2", "s3"} {
+		play(t, s,
+			call(ALICE, "registerService", name, "t", "d", "alice"),
+			call(ALICE, "publishService", name),
+		)
+		if name == "s0" {
+			s.now += 30 * 24 * 3600
+		}
+	}
+	play(t, s,
+		call(BOB, "voteService", "s1", "up"),
+		call(ADMIN, "voteService", "s1", "up"),
+		call(BOB, "voteService", "s3", "down"),
+	)
+	for i := 0; i < 5; i++ {
+		ok(t, s.invoke(BOB, "invokeService", "s2"))
+	}
+	ranking := func() ([]string, map[string]int) {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(BOB, "queryServiceByRange", "", "", "trust")), &got)
+		names := []string{}
+		scores := map[string]int{}
+		for _, r := range got {
+			name := r["Record"].(map[string]interface{})["name"].(string)
+			names = append(names, name)
+			scores[name] = int(r["TrustScore"].(float64))
+		}
+		return names, scores
+	}
+	// the oldest first, then by net rating, invocations and downvotes
+	names, scores := ranking()
+	if strings.Join(names, ",") != "s0,s1,s2,s3" || scores["s1"]-scores["s2"] != 15 || scores["s0"]-scores["s3"] != 40 {
+		t.Fatal(names, scores)
+	}
+
+	// weighing the invocations only, ties keep the name order
+	play(t, s,
+		call(ADMIN, "setConfig", "TRUST_RATING", "0"),
+		call(ADMIN, "setConfig", "TRUST_AGE", "0"),
+	)
+	if names, scores = ranking(); strings.Join(names, ",") != "s2,s0,s1,s3" || scores["s2"]-scores["s0"] != 5 {
+		t.Fatal(names, scores)
+	}
+}
+
+func TestServiceByRangeMinRating(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		play(t, s,
+			call(ALICE, "registerService", name, "t", "d", "alice"),
+			call(ALICE, "publishService", name),
+		)
+	}
+	// a: 2, b: -1, c: 0, d: 1, e: 2
+	play(t, s,
+		call(BOB, "voteService", "a", "up"),
+		call(ADMIN, "voteService", "a", "up"),
+		call(BOB, "voteService", "b", "down"),
+		call(BOB, "voteService", "c", "up"),
+		call(ADMIN, "voteService", "c", "down"),
+		call(BOB, "voteService", "d", "up"),
+		call(BOB, "voteService", "e", "up"),
+		call(ADMIN, "voteService", "e", "up"),
+		call(ALICE, "editService", "a", "Description", "d2"),
+		fails(BOB, "queryServiceByRange", "", "", "name", "high"),
+	)
+	list := func(args ...string) string {
+		var got []map[string]interface{}
+		json.Unmarshal(ok(t, s.invoke(BOB, append([]string{"queryServiceByRange"}, args...)...)), &got)
+		names := []string{}
+		for i, r := range got {
+			if r["Number"] != strconv.Itoa(i+1) {
+				t.Fatal(got)
+			}
+			names = append(names, r["Record"].(map[string]interface{})["name"].(string))
+		}
+		return strings.Join(names, ",")
+	}
+	for _, c := range []struct{ args, want string }{
+		{",,name,0", "a,c,d,e"},
+		{",,name,1", "a,d,e"},
+		{",,name,-1", "a,b,c,d,e"},
+		{",,name,3", ""},
+		{",,updated,1", "d,e,a"},
+		{",,trust,1", "a,e,d"},
+		{"b,d,name,0", "c"},
+		{"d,,name,1", "d,e"},
+	} {
+		if got := list(strings.Split(c.args, ",")...); got != c.want {
+			t.Fatal(c.args, got)
+		}
+	}
+
+	// a corrupt record fails the query rather than passing as unrated
+	s.state[ServicePrefix+"f"] = []byte("{")
+	if msg := bad(t, s.invoke(BOB, "queryServiceByRange", "", "", "name", "-1")); !strings.Contains(msg, ServicePrefix+"f") {
+		t.Fatal(msg)
+	}
+}
+
+func TestServiceByRangeDiscovery(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		play(t, s,
+			call(ALICE, "registerService", name, "t", "d", "alice"),
+			call(ALICE, "publishService", name),
+		)
+	}
+	play(t, s,
+		call(BOB, "voteService", "a", "up"),
+		call(ADMIN, "voteService", "a", "up"),
+		call(BOB, "voteService", "c", "up"),
+		call(BOB, "voteService", "f", "up"),
+		fails(ALICE, "setServicePriority", "b", "1"),
+		fails(ADMIN, "setServicePriority", "b", "high"),
+		fails(ADMIN, "setServicePriority", "x", "1"),
+		call(ADMIN, "setServicePriority", "b", "1"),
+		call(ADMIN, "setServicePriority", "c", "1"),
+		call(ADMIN, "setServicePriority", "f", "-1"),
+	)
+	if p := getSvc(t, s, "c")["priority"]; p != 1.0 {
+		t.Fatal(p)
+	}
+	var got []map[string]interface{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServiceByRange", "", "", "discovery")), &got)
+	names := []string{}
+	for _, r := range got {
+		names = append(names, r["Record"].(map[string]interface{})["name"].(string))
+		if _, scored := r["TrustScore"]; !scored {
+			t.Fatal(r)
+		}
+	}
+	// priority first, the better trusted first within a priority, then the name
+	if strings.Join(names, ",") != "c,b,a,d,e,f" {
+		t.Fatal(names)
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	s.issueToken(ADMIN, "TOK", 1000)
+	play(t, s,
+		call(ADMIN, "initAccount", "TOK", "1000", "2", ALICE),
+		fails(ALICE, "exportState"),
+	)
+	dump := ok(t, s.invoke(ADMIN, "exportState"))
+	snap := map[string][]map[string]interface{}{}
+	json.Unmarshal(dump, &snap)
+	if len(snap["users"]) != 1 || len(snap["services"]) != 1 || len(snap["tokens"]) != 1 || snap["tokens"][0]["key"] != "TOK" {
+		t.Fatal(string(dump))
+	}
+	// the limit counts the three exported records, not every key
+	play(t, s,
+		call(ADMIN, "setConfig", "SCAN_LIMIT", "3"),
+		call(ADMIN, "exportState"),
+		call(ADMIN, "setConfig", "SCAN_LIMIT", "2"),
+		fails(ADMIN, "exportState"),
+	)
+
+	r := newStub(t)
+	play(t, r,
+		call(BOB, "registerUser", "alice", "squatter"),
+		fails(ADMIN, "importState", `{"users":[{"key":"USER_bob","record":{"name":"alice"}}]}`),
+	)
+	res := map[string]int{}
+	json.Unmarshal(ok(t, r.invoke(ADMIN, "importState", string(dump))), &res)
+	if res["imported"] != 2 || res["skipped"] != 1 {
+		t.Fatal(res)
+	}
+	if getSvc(t, r, "s1")["developer"] != "alice" {
+		t.Fatal("service not imported")
+	}
+	ok(t, r.invoke(BOB, "queryRecentServices", "5"))
+	json.Unmarshal(ok(t, r.invoke(ADMIN, "importState", string(dump))), &res)
+	if res["imported"] != 0 || res["skipped"] != 3 {
+		t.Fatal(res)
+	}
+}
+
+func TestReclaimService(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(BOB, "registerUser", "bob", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "publishService", "s2"),
+		fails(BOB, "reclaimService", "s1"),
+	)
+	s.now += 31 * 24 * 3600
+	play(t, s,
+		fails(BOB, "reclaimService", "s2"),
+		call(BOB, "reclaimService", "s1"),
+		call(BOB, "registerService", "s1", "t", "d", "bob"),
+	)
+}
+
+func TestServicesCreatedBetween(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	from := time.Unix(s.now+1, 0).UTC().Format(time.RFC3339)
+	play(t, s,
+		call(ALICE, "registerService", "s2", "t", "d", "alice"),
+		call(ALICE, "registerService", "s3", "t", "d", "alice"),
+	)
+	to := time.Unix(s.now, 0).UTC().Format(time.RFC3339)
+	play(t, s,
+		call(ALICE, "registerService", "s4", "t", "d", "alice"),
+		fails(BOB, "queryServicesCreatedBetween", to, from),
+		fails(BOB, "queryServicesCreatedBetween", "yesterday", to),
+	)
+	s.state[ServicePrefix+"bad"] = []byte(`{"name":"bad","developer":"alice","createdTime":"someday"}`)
+	var got struct {
+		Services []map[string]interface{}
+		Skipped  []string
+	}
+	json.Unmarshal(ok(t, s.invoke(BOB, "queryServicesCreatedBetween", from, to)), &got)
+	if len(got.Services) != 2 || got.Services[0]["name"] != "s2" || got.Services[1]["name"] != "s3" {
+		t.Fatal(got.Services)
+	}
+	if len(got.Skipped) != 1 || got.Skipped[0] != "bad" {
+		t.Fatal(got.Skipped)
+	}
+}
+
+func TestInitAccountTwice(t *testing.T) {
+	s := newStub(t)
+	s.issueToken(ADMIN, "TOK", 1000)
+	ok(t, s.invoke(ADMIN, "initAccount", "TOK", "100", "2", ALICE))
+	msg := bad(t, s.invoke(ADMIN, "initAccount", "TOK", "100", "2", ALICE))
+	if s.balances[ALICE]["TOK"].Int64() != 100 || !strings.Contains(msg, "issued") {
+		t.Fatal(msg, s.balances)
+	}
+	// an address already holding the token is refused even without a record
+	s.issueToken(BOB, "OLD", 5)
+	s.issueToken(ADMIN, "OLD", 100)
+	msg = bad(t, s.invoke(ADMIN, "initAccount", "OLD", "100", "2", BOB))
+	if !strings.Contains(msg, "Token already issued") || s.balances[BOB]["OLD"].Int64() != 5 {
+		t.Fatal(msg, s.balances)
+	}
+}
+
+func TestRegisterReward(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ADMIN, "setIncentiveAmount", "1", "40"),
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+	)
+	earned_key := "\x00earned~user~token\x00alice\x00INK\x00"
+	if _, earned := s.state[earned_key]; earned {
+		t.Fatal("the developer paid themselves")
+	}
+	play(t, s,
+		fails(ALICE, "givesToken", "INK", "alice", "1"),
+		call(ADMIN, "givesToken", "INK", "alice", "1"),
+	)
+	if string(s.state[earned_key]) != "40" {
+		t.Fatal(string(s.state[earned_key]))
+	}
+}
+
+func TestIncentiveAmounts(t *testing.T) {
+	s := newStub(t)
+	if !strings.Contains(string(s.state["INCENTIVE_AMOUNTS"]), `"5":"510"`) {
+		t.Fatal(string(s.state["INCENTIVE_AMOUNTS"]))
+	}
+	play(t, s,
+		fails(ALICE, "setIncentiveAmount", "6", "3"),
+		fails(ADMIN, "setIncentiveAmount", "8", "3"),
+		fails(ADMIN, "setIncentiveAmount", "6", "x"),
+		call(ADMIN, "setIncentiveAmount", "6", "3"),
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		call(ALICE, "publishService", "s1"),
+		call(BOB, "commentService", "s1", "nice"),
+	)
+	if s.balances[ALICE]["INK"].Int64() != 1003 {
+		t.Fatal(s.balances)
+	}
+	bad(t, s.invoke(BOB, "givesToken", "INK", "alice", "9"))
+}
+
+func TestMashupCompositionLimit(t *testing.T) {
+	s := newStub(t)
+	ok(t, s.invoke(ALICE, "registerUser", "alice", "hi"))
+	for _, name := range []string{"s1", "s2", "s3"} {
+		ok(t, s.invoke(ALICE, "registerService", name, "t", "d", "alice"))
+		ok(t, s.invoke(ALICE, "publishService", name))
+	}
+	play(t, s,
+		fails(BOB, "createMashup", "m", "t", "d", "s1", "S1"),
+		call(ADMIN, "setConfig", "MAX_MASHUP_COMPOSITION", "2"),
+	)
+	msg := bad(t, s.invoke(BOB, "createMashup", "m", "t", "d", "s1", "s2", "s3"))
+	if !strings.Contains(msg, "at most, got 3") {
+		t.Fatal(msg)
+	}
+	ok(t, s.invoke(BOB, "createMashup", "m", "t", "d", "s1", "s2"))
+	limits := map[string]int{}
+	json.Unmarshal(ok(t, s.invoke(BOB, "getLimits")), &limits)
+	if limits["composition"] != 2 {
+		t.Fatal(limits)
+	}
+}
+
+func TestRevalidateService(t *testing.T) {
+	s := newStub(t)
+	play(t, s,
+		call(ALICE, "registerUser", "alice", "hi"),
+		call(ALICE, "registerService", "s1", "t", "d", "alice"),
+		fails(ALICE, "revalidateService", "s1"),
+		call(ALICE, "publishService", "s1"),
+		call(ALICE, "invalidateService", "s1"),
+		fails(ALICE, "publishService", "s1"),
+		fails(BOB, "revalidateService", "s1"),
+		fails(ALICE, "revalidateService", "nope"),
+	)
+	before := getSvc(t, s, "s1")
+	ok(t, s.invoke(ALICE, "revalidateService", "s1"))
+	after := getSvc(t, s, "s1")
+	if after["status"] != "available" || after["updatedTime"] == before["updatedTime"] || after["version"] != before["version"] {
+		t.Fatal(before, after)
+	}
+	if _, emitted := s.events["ServiceRevalidated"]; !emitted {
+		t.Fatal(s.events)
+	}
+}